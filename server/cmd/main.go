@@ -10,11 +10,18 @@ The main function initializes the database and logs any errors that occur during
 package main
 
 import (
+	"context"
 	"log"
+	"server/config"
 	"server/db"
+	"server/internal/audit"
+	"server/internal/mailer"
+	"server/internal/throttle"
 	"server/internal/users"
 	"server/router"
 	"server/ws"
+	"time"
+	_ "time/tzdata" // Embeds the time zone database, profile time zones are validated with time.LoadLocation.
 )
 
 /*
@@ -30,23 +37,65 @@ The function initializes the database using the NewDatabase function from the "s
 */
 
 func main() {
-	dbConn, err := db.NewDatabase()
+	cfg, err := config.Load("")
 	if err != nil {
-		log.Fatal("Error: %s", err)
+		log.Fatalf("Error: %s", err)
 	}
 
-	// Intialize Users
-	userRep := users.NewRepository(dbConn.GetDB())
-	userSvc := users.NewService(userRep)
-	userHandler := users.NewHandler(userSvc)
+	dbConn, err := db.NewDatabase()
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
 
 	// Initialize Websockets
 	websocketHub := ws.NewHub()
 	websocketHandler := ws.NewHandler(websocketHub)
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+
+	// Failed logins are counted in the configured store, "postgres" shares them between server nodes
+	loginGuard, err := throttle.New(cfg.Throttle, dbConn.GetDB())
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+
+	// Intialize Users, revoking a session also closes its websockets
+	auditLog := audit.New(dbConn.GetDB())
+
+	userRep := users.NewRepository(dbConn.GetDB())
+	userSvc, err := users.NewService(userRep, cfg,
+		users.WithSessionTerminator(websocketHub),
+		users.WithMailer(mail),
+		users.WithLoginGuard(loginGuard),
+		users.WithUserContent(websocketHub),
+		users.WithPresence(websocketHub),
+		users.WithBlockCache(websocketHub),
+		users.WithContactNotifier(websocketHub),
+		users.WithConnectionCounter(websocketHub),
+		users.WithAuditLog(auditLog),
+	)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	// Give the configured accounts the admin role, a database outage only delays it to the next start
+	if err := userSvc.BootstrapAdmins(context.Background(), cfg.Auth.Admins); err != nil {
+		log.Printf("admin bootstrap: %v", err)
+	}
+	// Purge the accounts whose deletion grace period is over
+	go users.RunAccountPurge(context.Background(), userSvc, time.Hour)
+	// The hub records when users were last connected and loads whom they blocked
+	websocketHub.LastSeen = userSvc
+	websocketHub.Blocks = userSvc
+	userHandler := users.NewHandler(userSvc)
 	// Run the websocket on separate goroutines
 	go websocketHub.Run()
 
-	router.InitHandler(userHandler, websocketHandler)
+	if err := router.InitHandler(cfg, userHandler, websocketHandler); err != nil {
+		log.Fatalf("Error: %s", err)
+	}
 	router.Start("0.0.0.0:8080")
 
 }
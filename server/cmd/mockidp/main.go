@@ -0,0 +1,44 @@
+/*
+Package main runs the minimal OpenID Connect identity provider of package oidctest for local development.
+
+Point the server at it with:
+
+	"oidc": {"enabled": true, "issuer": "http://localhost:9000", "client_id": "go-chat", "auto_provision": true}
+
+The signed in identity is set with flags, and can be changed per login with a
+login_hint=<email> parameter on the authorization request.
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"server/internal/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, must match the server's oidc.issuer")
+	clientID := flag.String("client-id", "go-chat", "accepted client_id")
+	subject := flag.String("sub", "mock-user-1", "subject of the signed in identity")
+	email := flag.String("email", "sso.user@example.com", "email of the signed in identity")
+	emailVerified := flag.Bool("email-verified", true, "email_verified claim of the signed in identity")
+	username := flag.String("username", "sso.user", "preferred_username of the signed in identity")
+	name := flag.String("name", "SSO User", "name of the signed in identity")
+	flag.Parse()
+
+	p, err := oidctest.New(*issuer, *clientID, oidctest.Identity{
+		Subject:       *subject,
+		Email:         *email,
+		EmailVerified: *emailVerified,
+		Username:      *username,
+		Name:          *name,
+	})
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+
+	log.Printf("mock identity provider %s listening on %s", p.Issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p.Handler()))
+}
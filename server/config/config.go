@@ -0,0 +1,439 @@
+// Package config loads the server configuration from a JSON file.
+//
+// Every setting has a default, so the server also starts without a configuration file.
+// The file is read from the path in the CONFIG_PATH environment variable, or "config.json"
+// in the working directory. See config.example.json for a complete example.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config is the root of the server configuration.
+type Config struct {
+	// App holds settings about the public deployment of the server.
+	App AppConfig `json:"app"`
+
+	// JWT configures how session tokens are signed and verified.
+	JWT JWTConfig `json:"jwt"`
+
+	// Auth configures account policies.
+	Auth AuthConfig `json:"auth"`
+
+	// Mail configures how emails are delivered.
+	Mail MailConfig `json:"mail"`
+
+	// OIDC configures login through an external OpenID Connect identity provider.
+	OIDC OIDCConfig `json:"oidc"`
+
+	// Throttle configures the brute-force protection of the login.
+	Throttle ThrottleConfig `json:"throttle"`
+}
+
+// AppConfig holds settings about the public deployment of the server.
+type AppConfig struct {
+	// BaseURL is the public URL used to build links in emails, without a trailing slash.
+	BaseURL string `json:"base_url"`
+
+	// Name is shown to users, for example as the issuer in authenticator apps.
+	Name string `json:"name"`
+
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies whose X-Forwarded-For
+	// header is believed. Without any, the client IP is the address of the TCP connection.
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// JWTConfig configures the signing keys and lifetimes of session tokens.
+type JWTConfig struct {
+	// Issuer is written to and required in the "iss" claim.
+	Issuer string `json:"issuer"`
+
+	// ActiveKey is the kid of the key that signs new tokens.
+	// Every other key in Keys is only used to verify tokens signed before a rotation.
+	ActiveKey string `json:"active_key"`
+
+	// Keys lists every key that is currently accepted.
+	Keys []KeyConfig `json:"keys"`
+
+	// AccessTokenTTL is how long an access token (the "jwt" cookie) stays valid.
+	AccessTokenTTL Duration `json:"access_token_ttl"`
+
+	// RefreshTokenTTL is how long an unused refresh token stays valid.
+	RefreshTokenTTL Duration `json:"refresh_token_ttl"`
+}
+
+// KeyConfig describes one JWT key.
+type KeyConfig struct {
+	// ID is the key ID written to the "kid" header of tokens signed with the key.
+	ID string `json:"kid"`
+
+	// Algorithm is one of "HS256", "RS256" or "EdDSA".
+	Algorithm string `json:"alg"`
+
+	// SecretEnv names the environment variable that holds an HS256 secret.
+	SecretEnv string `json:"secret_env"`
+
+	// PrivateKeyFile is a PEM encoded RSA or Ed25519 private key.
+	PrivateKeyFile string `json:"private_key_file"`
+
+	// PublicKeyFile is a PEM encoded RSA or Ed25519 public key.
+	// It is used for retired keys that may still verify tokens but no longer sign them.
+	PublicKeyFile string `json:"public_key_file"`
+}
+
+// Policies for accounts whose email address is not verified yet.
+const (
+	// UnverifiedBlock refuses to log in unverified accounts.
+	UnverifiedBlock = "block"
+	// UnverifiedLimit logs them in, but routes behind RequireVerifiedEmail stay closed.
+	UnverifiedLimit = "limit"
+)
+
+// AuthConfig configures account policies.
+type AuthConfig struct {
+	// UnverifiedEmail is UnverifiedBlock or UnverifiedLimit.
+	UnverifiedEmail string `json:"unverified_email"`
+
+	// EmailVerificationTTL is how long an email verification link stays valid.
+	EmailVerificationTTL Duration `json:"email_verification_ttl"`
+
+	// PasswordResetTTL is how long a password reset link stays valid.
+	PasswordResetTTL Duration `json:"password_reset_ttl"`
+
+	// LoginChallengeTTL is how long the password step of a two-factor login stays valid.
+	LoginChallengeTTL Duration `json:"login_challenge_ttl"`
+
+	// MagicLinkTTL is how long an emailed passwordless login link stays valid.
+	MagicLinkTTL Duration `json:"magic_link_ttl"`
+
+	// TOTPKeyEnv names the environment variable holding the base64 encoded 32 byte key that encrypts
+	// TOTP secrets in the database. Without it, users cannot set up two-factor authentication.
+	TOTPKeyEnv string `json:"totp_key_env"`
+
+	// Admins lists the email addresses of the accounts given the admin role when the server starts,
+	// so a new deployment has someone to hand out the other roles.
+	Admins []string `json:"admins"`
+
+	// AccountDeletionGrace is how long a deleted account can still be restored by logging in
+	// before it is purged. Zero purges it on the next run of the purge job.
+	AccountDeletionGrace Duration `json:"account_deletion_grace"`
+
+	// Password is the policy new passwords must follow.
+	Password PasswordPolicyConfig `json:"password"`
+
+	// PasswordHash configures how new passwords are hashed.
+	PasswordHash PasswordHashConfig `json:"password_hash"`
+}
+
+// PasswordHashConfig selects the algorithm and parameters of new password hashes.
+// Hashes made with other settings keep working and are replaced on the next successful login.
+type PasswordHashConfig struct {
+	// Algorithm is "argon2id" or "bcrypt".
+	Algorithm string `json:"algorithm"`
+
+	// Argon2 configures the "argon2id" algorithm.
+	Argon2 Argon2Config `json:"argon2"`
+
+	// BcryptCost is the cost factor of the "bcrypt" algorithm.
+	BcryptCost int `json:"bcrypt_cost"`
+}
+
+// Argon2Config holds the cost parameters of argon2id.
+type Argon2Config struct {
+	// MemoryKiB is the memory used per hash in KiB.
+	MemoryKiB uint32 `json:"memory_kib"`
+
+	// Iterations is the number of passes over the memory.
+	Iterations uint32 `json:"iterations"`
+
+	// Parallelism is the number of threads used per hash.
+	Parallelism uint8 `json:"parallelism"`
+}
+
+// PasswordPolicyConfig is the policy enforced when a password is set, on registration, change and reset.
+type PasswordPolicyConfig struct {
+	// MinLength is the minimum number of characters.
+	MinLength int `json:"min_length"`
+
+	// MaxLength is the maximum size in bytes. It may be at most 72 with the bcrypt algorithm, which refuses longer passwords.
+	MaxLength int `json:"max_length"`
+
+	// MinCharClasses is how many of lowercase letters, uppercase letters, digits and symbols must be used.
+	MinCharClasses int `json:"min_char_classes"`
+
+	// DisallowPersonalInfo refuses passwords that contain the username or the email address.
+	DisallowPersonalInfo bool `json:"disallow_personal_info"`
+
+	// BreachedDir is a directory of breached password hashes split by SHA-1 prefix, as downloaded
+	// with the haveibeenpwned downloader: one file per 5 hex digit prefix, such as "21BD1.txt",
+	// with "SUFFIX:COUNT" lines. Empty disables the check.
+	BreachedDir string `json:"breached_dir"`
+
+	// BreachedMinCount is how often a password must have been seen in breaches to be refused.
+	BreachedMinCount int `json:"breached_min_count"`
+}
+
+// MailConfig configures how emails are delivered.
+type MailConfig struct {
+	// Driver is "smtp", "file" or "log".
+	Driver string `json:"driver"`
+
+	// From is the sender address.
+	From string `json:"from"`
+
+	// Dir is where the "file" driver writes .eml files.
+	Dir string `json:"dir"`
+
+	// SMTP configures the "smtp" driver.
+	SMTP SMTPConfig `json:"smtp"`
+}
+
+// SMTPConfig configures the SMTP server used by the "smtp" mail driver.
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+
+	// PasswordEnv names the environment variable that holds the SMTP password.
+	PasswordEnv string `json:"password_env"`
+}
+
+// OIDCConfig configures login through an external OpenID Connect identity provider
+// with the authorization code flow and PKCE.
+type OIDCConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Issuer is the identity provider's issuer URL, its discovery document is read from
+	// <issuer>/.well-known/openid-configuration.
+	Issuer string `json:"issuer"`
+
+	ClientID string `json:"client_id"`
+
+	// ClientSecretEnv names the environment variable holding the client secret.
+	// It may stay empty for public clients, which rely on PKCE alone.
+	ClientSecretEnv string `json:"client_secret_env"`
+
+	// RedirectURL is the callback registered at the provider, <app.base_url>/oidc/callback by default.
+	RedirectURL string `json:"redirect_url"`
+
+	// Scopes requested from the provider, "openid" must be one of them.
+	Scopes []string `json:"scopes"`
+
+	// AutoProvision creates an account for identities that match no existing user.
+	AutoProvision bool `json:"auto_provision"`
+
+	// PostLoginRedirect is where the browser is sent after a successful login.
+	// When empty the callback answers with JSON instead.
+	PostLoginRedirect string `json:"post_login_redirect"`
+}
+
+// ThrottleConfig configures the brute-force protection of the login.
+// Failures are counted per account and per client IP, each with its own rule.
+type ThrottleConfig struct {
+	// Store is "memory" for a single server node or "postgres" to share the counters between nodes.
+	Store string `json:"store"`
+
+	// Account limits failed logins of one email address, from any IP.
+	Account ThrottleRule `json:"account"`
+
+	// IP limits failed logins from one client IP, for any account.
+	IP ThrottleRule `json:"ip"`
+
+	// MagicLink limits the login links mailed to one email address. Every request counts, not only failures.
+	MagicLink ThrottleRule `json:"magic_link"`
+
+	// Mail limits the password reset and verification emails mailed to one address, each kind counted apart.
+	// Every request counts, not only failures.
+	Mail ThrottleRule `json:"mail"`
+
+	// MailIP limits the emails requested from one client IP, for any address.
+	MailIP ThrottleRule `json:"mail_ip"`
+}
+
+// ThrottleRule is the backoff and lockout policy for one kind of throttle key.
+// After FreeAttempts failures each new attempt has to wait BaseDelay, doubling with every failure up to MaxDelay.
+// After LockoutAfter failures the key is locked for LockoutDuration. Failures older than Window are forgotten.
+type ThrottleRule struct {
+	FreeAttempts    int      `json:"free_attempts"`
+	BaseDelay       Duration `json:"base_delay"`
+	MaxDelay        Duration `json:"max_delay"`
+	LockoutAfter    int      `json:"lockout_after"`
+	LockoutDuration Duration `json:"lockout_duration"`
+	Window          Duration `json:"window"`
+}
+
+// Duration is a time.Duration that is written as a string such as "15m" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"15m\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Default returns the configuration used for settings missing from the file.
+func Default() *Config {
+	return &Config{
+		App: AppConfig{
+			BaseURL: "http://localhost:8080",
+			Name:    "go-chat",
+		},
+		JWT: JWTConfig{
+			Issuer:          "go-chat",
+			AccessTokenTTL:  Duration{15 * time.Minute},
+			RefreshTokenTTL: Duration{30 * 24 * time.Hour},
+		},
+		Auth: AuthConfig{
+			UnverifiedEmail:      UnverifiedLimit,
+			EmailVerificationTTL: Duration{24 * time.Hour},
+			PasswordResetTTL:     Duration{time.Hour},
+			LoginChallengeTTL:    Duration{5 * time.Minute},
+			MagicLinkTTL:         Duration{15 * time.Minute},
+			TOTPKeyEnv:           "TOTP_KEY",
+			AccountDeletionGrace: Duration{30 * 24 * time.Hour},
+			Password: PasswordPolicyConfig{
+				MinLength:            8,
+				MaxLength:            128,
+				DisallowPersonalInfo: true,
+				BreachedMinCount:     1,
+			},
+			PasswordHash: PasswordHashConfig{
+				Algorithm: "argon2id",
+				Argon2: Argon2Config{
+					MemoryKiB:   64 * 1024,
+					Iterations:  3,
+					Parallelism: 2,
+				},
+				BcryptCost: 10,
+			},
+		},
+		Mail: MailConfig{
+			Driver: "log",
+			From:   "go-chat <no-reply@localhost>",
+			SMTP: SMTPConfig{
+				Port: 587,
+			},
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"openid", "email", "profile"},
+		},
+		Throttle: ThrottleConfig{
+			Store: "memory",
+			Account: ThrottleRule{
+				FreeAttempts:    3,
+				BaseDelay:       Duration{time.Second},
+				MaxDelay:        Duration{time.Minute},
+				LockoutAfter:    10,
+				LockoutDuration: Duration{15 * time.Minute},
+				Window:          Duration{15 * time.Minute},
+			},
+			IP: ThrottleRule{
+				FreeAttempts:    10,
+				BaseDelay:       Duration{time.Second},
+				MaxDelay:        Duration{time.Minute},
+				LockoutAfter:    100,
+				LockoutDuration: Duration{15 * time.Minute},
+				Window:          Duration{15 * time.Minute},
+			},
+			MagicLink: ThrottleRule{
+				FreeAttempts: 3,
+				BaseDelay:    Duration{time.Minute},
+				MaxDelay:     Duration{15 * time.Minute},
+				Window:       Duration{time.Hour},
+			},
+			Mail: ThrottleRule{
+				FreeAttempts: 3,
+				BaseDelay:    Duration{time.Minute},
+				MaxDelay:     Duration{15 * time.Minute},
+				Window:       Duration{time.Hour},
+			},
+			MailIP: ThrottleRule{
+				FreeAttempts: 20,
+				BaseDelay:    Duration{time.Minute},
+				MaxDelay:     Duration{15 * time.Minute},
+				Window:       Duration{time.Hour},
+			},
+		},
+	}
+}
+
+// Load reads the configuration file at path on top of Default.
+// An empty path means the CONFIG_PATH environment variable, or "config.json".
+// A missing file is not an error when the path was not given explicitly.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("CONFIG_PATH")
+		explicit = path != ""
+	}
+	if path == "" {
+		path = "config.json"
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		cfg.OIDC.RedirectURL = strings.TrimRight(cfg.App.BaseURL, "/") + "/oidc/callback"
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error on Load Config: %w", err)
+	}
+
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("Error on Parse Config %s: %w", path, err)
+	}
+
+	if cfg.OIDC.RedirectURL == "" {
+		cfg.OIDC.RedirectURL = strings.TrimRight(cfg.App.BaseURL, "/") + "/oidc/callback"
+	}
+	if cfg.OIDC.Enabled && (cfg.OIDC.Issuer == "" || cfg.OIDC.ClientID == "") {
+		return nil, fmt.Errorf("Error on Parse Config %s: oidc needs an issuer and a client_id", path)
+	}
+
+	if cfg.Throttle.Store != "memory" && cfg.Throttle.Store != "postgres" {
+		return nil, fmt.Errorf("Error on Parse Config %s: throttle.store must be \"memory\" or \"postgres\"", path)
+	}
+
+	if p := cfg.Auth.Password; p.MinLength < 1 || p.MaxLength < p.MinLength || p.MinCharClasses > 4 {
+		return nil, fmt.Errorf("Error on Parse Config %s: auth.password needs 1 <= min_length <= max_length and at most 4 min_char_classes", path)
+	}
+
+	switch h := cfg.Auth.PasswordHash; {
+	case h.Algorithm == "argon2id" && (h.Argon2.MemoryKiB < 8*uint32(h.Argon2.Parallelism) || h.Argon2.Iterations < 1 || h.Argon2.Parallelism < 1):
+		return nil, fmt.Errorf("Error on Parse Config %s: auth.password_hash.argon2 needs iterations and parallelism of at least 1 and 8 KiB of memory per thread", path)
+	case h.Algorithm == "bcrypt" && (h.BcryptCost < 4 || h.BcryptCost > 31):
+		return nil, fmt.Errorf("Error on Parse Config %s: auth.password_hash.bcrypt_cost must be between 4 and 31", path)
+	case h.Algorithm == "bcrypt" && cfg.Auth.Password.MaxLength > 72:
+		return nil, fmt.Errorf("Error on Parse Config %s: auth.password.max_length must be at most 72 with bcrypt", path)
+	case h.Algorithm != "argon2id" && h.Algorithm != "bcrypt":
+		return nil, fmt.Errorf("Error on Parse Config %s: auth.password_hash.algorithm must be \"argon2id\" or \"bcrypt\"", path)
+	}
+
+	if cfg.Auth.UnverifiedEmail != UnverifiedBlock && cfg.Auth.UnverifiedEmail != UnverifiedLimit {
+		return nil, fmt.Errorf("Error on Parse Config %s: auth.unverified_email must be %q or %q", path, UnverifiedBlock, UnverifiedLimit)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "refresh_tokens"(
+    "id" bigserial PRIMARY KEY,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "family_id" varchar NOT NULL,
+    "token_hash" varchar NOT NULL UNIQUE,
+    "expires_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "used_at" timestamptz,
+    "revoked_at" timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS "refresh_tokens_family_id_idx" ON "refresh_tokens" ("family_id");
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "revoked_sessions"(
+    "session_id" varchar PRIMARY KEY,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "reason" varchar NOT NULL,
+    "revoked_at" timestamptz NOT NULL DEFAULT now(),
+    "expires_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS "revoked_sessions_expires_at_idx" ON "revoked_sessions" ("expires_at");
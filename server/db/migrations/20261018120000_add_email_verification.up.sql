@@ -0,0 +1,13 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "email_verified" boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS "user_tokens"(
+    "id" bigserial PRIMARY KEY,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "purpose" varchar NOT NULL,
+    "token_hash" varchar NOT NULL UNIQUE,
+    "expires_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "used_at" timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS "user_tokens_user_id_purpose_idx" ON "user_tokens" ("user_id", "purpose");
@@ -0,0 +1,13 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_secret" varchar NOT NULL DEFAULT '';
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_enabled" boolean NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_last_step" bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "recovery_codes"(
+    "id" bigserial PRIMARY KEY,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "code_hash" varchar NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "used_at" timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS "recovery_codes_user_id_idx" ON "recovery_codes" ("user_id");
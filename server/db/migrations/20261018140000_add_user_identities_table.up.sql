@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "user_identities"(
+    "id" bigserial PRIMARY KEY,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "issuer" varchar NOT NULL,
+    "subject" varchar NOT NULL,
+    "email" varchar NOT NULL DEFAULT '',
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    UNIQUE ("issuer", "subject")
+);
+
+CREATE INDEX IF NOT EXISTS "user_identities_user_id_idx" ON "user_identities" ("user_id");
@@ -0,0 +1,5 @@
+ALTER TABLE "users" DROP COLUMN IF EXISTS "updated_at";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "timezone";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "avatar_url";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "bio";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "display_name";
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "display_name" varchar NOT NULL DEFAULT '';
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "bio" text NOT NULL DEFAULT '';
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "avatar_url" varchar NOT NULL DEFAULT '';
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "timezone" varchar NOT NULL DEFAULT 'UTC';
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "updated_at" timestamptz NOT NULL DEFAULT now();
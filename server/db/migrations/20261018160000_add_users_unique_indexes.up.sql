@@ -0,0 +1,3 @@
+-- Emails and usernames are unique regardless of case. Existing duplicates must be merged before this runs.
+CREATE UNIQUE INDEX IF NOT EXISTS "users_email_key" ON "users" (lower("email"));
+CREATE UNIQUE INDEX IF NOT EXISTS "users_username_key" ON "users" (lower("username"));
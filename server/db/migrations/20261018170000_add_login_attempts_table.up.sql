@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "login_attempts"(
+    "key" varchar PRIMARY KEY,
+    "failures" integer NOT NULL DEFAULT 0,
+    "last_failure" timestamptz NOT NULL DEFAULT now(),
+    "locked_until" timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS "login_attempts_locked_until_idx" ON "login_attempts" ("locked_until") WHERE "locked_until" IS NOT NULL;
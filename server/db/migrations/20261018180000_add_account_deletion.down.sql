@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "users_delete_after_idx";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "delete_after";
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "delete_after" timestamptz;
+
+CREATE INDEX IF NOT EXISTS "users_delete_after_idx" ON "users" ("delete_after") WHERE "delete_after" IS NOT NULL;
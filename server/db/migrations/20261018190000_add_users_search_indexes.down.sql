@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "users_display_name_trgm_idx";
+DROP INDEX IF EXISTS "users_username_trgm_idx";
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "role" varchar NOT NULL DEFAULT 'user'
+    CONSTRAINT "users_role_check" CHECK ("role" IN ('user', 'moderator', 'admin'));
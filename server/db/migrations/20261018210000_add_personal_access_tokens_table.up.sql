@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "personal_access_tokens"(
+    "id" bigserial PRIMARY KEY,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "name" varchar NOT NULL,
+    "token_hash" varchar NOT NULL UNIQUE,
+    "scopes" text[] NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "expires_at" timestamptz NOT NULL,
+    "last_used_at" timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS "personal_access_tokens_user_id_idx" ON "personal_access_tokens" ("user_id");
@@ -0,0 +1,6 @@
+DELETE FROM "users" WHERE "is_bot";
+DROP INDEX IF EXISTS "users_bot_owner_id_idx";
+DROP INDEX IF EXISTS "users_email_key";
+CREATE UNIQUE INDEX IF NOT EXISTS "users_email_key" ON "users" (lower("email"));
+ALTER TABLE "users" DROP COLUMN IF EXISTS "bot_owner_id";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "is_bot";
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "is_bot" boolean NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "bot_owner_id" bigint REFERENCES "users" ("id") ON DELETE CASCADE;
+
+-- Bots have no email address, so only human accounts must have distinct ones.
+DROP INDEX IF EXISTS "users_email_key";
+CREATE UNIQUE INDEX IF NOT EXISTS "users_email_key" ON "users" (lower("email")) WHERE NOT "is_bot";
+CREATE INDEX IF NOT EXISTS "users_bot_owner_id_idx" ON "users" ("bot_owner_id") WHERE "bot_owner_id" IS NOT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "user_blocks"(
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "blocked_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "kind" varchar NOT NULL CONSTRAINT "user_blocks_kind_check" CHECK ("kind" IN ('block', 'mute')),
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("user_id", "blocked_id"),
+    CONSTRAINT "user_blocks_self_check" CHECK ("user_id" <> "blocked_id")
+);
+
+CREATE INDEX IF NOT EXISTS "user_blocks_blocked_id_idx" ON "user_blocks" ("blocked_id");
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "contacts"(
+    "requester_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "addressee_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "status" varchar NOT NULL DEFAULT 'pending' CONSTRAINT "contacts_status_check" CHECK ("status" IN ('pending', 'accepted')),
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "accepted_at" timestamptz,
+    PRIMARY KEY ("requester_id", "addressee_id"),
+    CONSTRAINT "contacts_self_check" CHECK ("requester_id" <> "addressee_id")
+);
+
+-- A pair of users has one row, whoever sent the request.
+CREATE UNIQUE INDEX IF NOT EXISTS "contacts_pair_key" ON "contacts" (least("requester_id", "addressee_id"), greatest("requester_id", "addressee_id"));
+CREATE INDEX IF NOT EXISTS "contacts_addressee_id_idx" ON "contacts" ("addressee_id");
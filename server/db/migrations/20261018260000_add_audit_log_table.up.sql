@@ -0,0 +1,33 @@
+-- user_id has no foreign key: audit rows outlive the accounts they are about.
+CREATE TABLE IF NOT EXISTS "audit_log"(
+    "id" bigserial PRIMARY KEY,
+    "type" varchar NOT NULL,
+    "user_id" bigint,
+    "email" varchar NOT NULL DEFAULT '',
+    "session_id" varchar NOT NULL DEFAULT '',
+    "ip" varchar NOT NULL DEFAULT '',
+    "user_agent" varchar NOT NULL DEFAULT '',
+    "success" boolean NOT NULL,
+    "reason" varchar NOT NULL DEFAULT '',
+    "details" jsonb NOT NULL DEFAULT '{}',
+    "created_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS "audit_log_user_id_idx" ON "audit_log" ("user_id", "id");
+CREATE INDEX IF NOT EXISTS "audit_log_type_idx" ON "audit_log" ("type", "id");
+CREATE INDEX IF NOT EXISTS "audit_log_created_at_idx" ON "audit_log" ("created_at");
+
+-- The audit log is append-only: rows can be inserted and read, never changed or deleted.
+CREATE OR REPLACE FUNCTION "audit_log_append_only"() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS "audit_log_no_change" ON "audit_log";
+CREATE TRIGGER "audit_log_no_change" BEFORE UPDATE OR DELETE ON "audit_log"
+    FOR EACH ROW EXECUTE FUNCTION "audit_log_append_only"();
+
+DROP TRIGGER IF EXISTS "audit_log_no_truncate" ON "audit_log";
+CREATE TRIGGER "audit_log_no_truncate" BEFORE TRUNCATE ON "audit_log"
+    FOR EACH STATEMENT EXECUTE FUNCTION "audit_log_append_only"();
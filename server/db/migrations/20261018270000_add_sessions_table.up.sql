@@ -0,0 +1,11 @@
+-- One row per login session. "id" is the family_id of its refresh tokens and the "sid" claim of its access tokens.
+CREATE TABLE IF NOT EXISTS "sessions"(
+    "id" varchar PRIMARY KEY,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "ip" varchar NOT NULL DEFAULT '',
+    "user_agent" varchar NOT NULL DEFAULT '',
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "last_active_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS "sessions_user_id_idx" ON "sessions" ("user_id");
@@ -0,0 +1,2 @@
+-- Set when an administrator deleted the account: logging in does not cancel such a deletion.
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "deleted_by_admin" boolean NOT NULL DEFAULT false;
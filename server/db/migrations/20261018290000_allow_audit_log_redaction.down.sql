@@ -0,0 +1,5 @@
+CREATE OR REPLACE FUNCTION "audit_log_append_only"() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$;
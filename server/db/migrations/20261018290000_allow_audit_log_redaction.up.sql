@@ -0,0 +1,21 @@
+-- The audit log stays append-only, except that the personal data of a purged account can be blanked:
+-- an update may only empty email, ip and user_agent, every other column must stay the same.
+CREATE OR REPLACE FUNCTION "audit_log_append_only"() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.id = OLD.id
+        AND NEW.type = OLD.type
+        AND NEW.user_id IS NOT DISTINCT FROM OLD.user_id
+        AND NEW.session_id = OLD.session_id
+        AND NEW.success = OLD.success
+        AND NEW.reason = OLD.reason
+        AND NEW.details = OLD.details
+        AND NEW.created_at = OLD.created_at
+        AND NEW.email IN ('', OLD.email)
+        AND NEW.ip IN ('', OLD.ip)
+        AND NEW.user_agent IN ('', OLD.user_agent) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$;
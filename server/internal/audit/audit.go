@@ -0,0 +1,200 @@
+// Package audit keeps an append-only trail of authentication events, such as logins and password changes,
+// in the audit_log table. The table refuses updates and deletes, so an event cannot be changed once recorded;
+// only the personal data of a purged account can be blanked, see Log.Redact.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"server/internal/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of events.
+const (
+	TypeRegister           = "register"
+	TypeLogin              = "login"
+	TypeMagicLinkRequested = "magic_link_requested"
+	TypeLogout             = "logout"
+	TypePasswordChange     = "password_change"
+	TypePasswordReset      = "password_reset"
+	TypeAccessTokenCreated = "access_token_created"
+	TypeAccessTokenDeleted = "access_token_deleted"
+	TypeBotTokenCreated    = "bot_token_created"
+)
+
+// Event is an entry of the audit log. Failed logins have Success unset and a Reason, the error code
+// answered to the client. Details holds what depends on the type, e.g. the login method or the token ID.
+type Event struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	UserID    *int64            `json:"user_id,omitempty"`
+	Email     string            `json:"email,omitempty"`
+	SessionID string            `json:"session_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Success   bool              `json:"success"`
+	Reason    string            `json:"reason,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Filter selects events. Zero fields match everything; Before is the ID of the last event of the previous page.
+type Filter struct {
+	Type    string
+	UserID  int64
+	Email   string
+	IP      string
+	Success *bool
+	Since   time.Time
+	Until   time.Time
+	Before  int64
+	Limit   int
+}
+
+// Log writes and reads the audit_log table.
+type Log struct {
+	db *sql.DB
+}
+
+// New creates a Log on the given database.
+func New(db *sql.DB) *Log {
+	return &Log{db: db}
+}
+
+// Record appends an event. The IP and user agent are taken from the util.ClientInfo of ctx when e has none.
+func (l *Log) Record(ctx context.Context, e Event) error {
+	info := util.ClientInfoFrom(ctx)
+	if e.IP == "" {
+		e.IP = info.IP
+	}
+	if e.UserAgent == "" {
+		e.UserAgent = info.UserAgent
+	}
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	query := "INSERT INTO audit_log (type, user_id, email, session_id, ip, user_agent, success, reason, details) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+
+	_, err = l.db.ExecContext(ctx, query, e.Type, e.UserID, e.Email, e.SessionID, e.IP, e.UserAgent, e.Success, e.Reason, details)
+	if err != nil {
+		return fmt.Errorf("audit: record %s: %w", e.Type, err)
+	}
+	return nil
+}
+
+// Redact blanks the email, IP and user agent of the events of a purged account: those recorded with its
+// user ID, and those recorded with its email address but no account, such as failed logins.
+// The events themselves stay. It is the only change the audit_log table allows.
+func (l *Log) Redact(ctx context.Context, userID int64, email string) error {
+	query := "UPDATE audit_log SET email = '', ip = '', user_agent = '' " +
+		"WHERE user_id = $1 OR ($2 <> '' AND lower(email) = lower($2))"
+
+	if _, err := l.db.ExecContext(ctx, query, userID, email); err != nil {
+		return fmt.Errorf("audit: redact user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// List returns the events matching f, newest first, at most f.Limit of them.
+func (l *Log) List(ctx context.Context, f Filter) ([]Event, error) {
+	events := []Event{}
+	err := l.query(ctx, f, func(e Event) error {
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+// Export writes every event matching f as JSON Lines, one event per line, newest first.
+// f.Limit is ignored. Rows are written while they are read, so large exports do not stay in memory.
+func (l *Log) Export(ctx context.Context, f Filter, w io.Writer) error {
+	f.Limit = 0
+	enc := json.NewEncoder(w)
+	return l.query(ctx, f, func(e Event) error {
+		return enc.Encode(e)
+	})
+}
+
+// query runs the select for f and calls fn with every row.
+func (l *Log) query(ctx context.Context, f Filter, fn func(Event) error) error {
+	where, args := f.where()
+	query := "SELECT id, type, user_id, email, session_id, ip, user_agent, success, reason, details, created_at FROM audit_log" +
+		where + " ORDER BY id DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Event
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.Type, &e.UserID, &e.Email, &e.SessionID, &e.IP, &e.UserAgent, &e.Success, &e.Reason, &details, &e.CreatedAt); err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return fmt.Errorf("audit: event %d: %w", e.ID, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// where builds the WHERE clause of the filter and its arguments.
+func (f Filter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Type != "" {
+		add("type = $%d", f.Type)
+	}
+	if f.UserID != 0 {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.Email != "" {
+		add("lower(email) = lower($%d)", f.Email)
+	}
+	if f.IP != "" {
+		add("ip = $%d", f.IP)
+	}
+	if f.Success != nil {
+		add("success = $%d", *f.Success)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	if f.Before > 0 {
+		add("id < $%d", f.Before)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
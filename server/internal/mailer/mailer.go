@@ -0,0 +1,145 @@
+// Package mailer sends transactional emails such as verification and password reset links.
+//
+// Mailer is implemented by SMTPMailer for production, and by FileMailer and LogMailer
+// for local development and tests, where no mail server is available.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"server/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New creates the Mailer selected by cfg.Driver: "smtp", "file" or "log" (the default).
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg), nil
+	case "file":
+		return NewFileMailer(cfg.Dir, cfg.From)
+	case "", "log":
+		return &LogMailer{From: cfg.From}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// format renders msg as an RFC 5322 message.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// SMTPMailer sends messages through an SMTP server.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates a SMTPMailer. PLAIN authentication is used when a username is configured,
+// the password is read from the environment variable named by cfg.SMTP.PasswordEnv.
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(cfg.SMTP.Host, strconv.Itoa(cfg.SMTP.Port)),
+		from: cfg.From,
+	}
+	if cfg.SMTP.Username != "" {
+		m.auth = smtp.PlainAuth("", cfg.SMTP.Username, os.Getenv(cfg.SMTP.PasswordEnv), cfg.SMTP.Host)
+	}
+	return m
+}
+
+// Send delivers the message with smtp.SendMail, which upgrades to TLS when the server supports it.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The envelope sender must be a bare address, the From header may carry a display name.
+	sender, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid mail from address: %w", err)
+	}
+	return smtp.SendMail(m.addr, m.auth, sender.Address, []string{msg.To}, format(m.from, msg))
+}
+
+// FileMailer writes every message to its own .eml file in a directory.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer creates a FileMailer, creating dir if needed.
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file mailer needs a mail dir")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to <dir>/<timestamp>-<recipient>.eml.
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	path := filepath.Join(m.dir, name)
+
+	if err := os.WriteFile(path, format(m.from, msg), 0o644); err != nil {
+		return err
+	}
+	log.Printf("mailer: wrote %q for %s to %s", msg.Subject, msg.To, path)
+	return nil
+}
+
+// sanitize keeps only characters that are safe in a file name.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// LogMailer writes every message to the standard logger.
+type LogMailer struct {
+	From string
+}
+
+// Send logs the message.
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mailer: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
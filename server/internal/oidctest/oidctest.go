@@ -0,0 +1,236 @@
+// Package oidctest is a minimal OpenID Connect identity provider for local development and tests.
+//
+// It implements just enough of the authorization code flow with PKCE to exercise the
+// server's /oidc/login and /oidc/callback routes without a real identity provider:
+// discovery, a JWKS endpoint, an authorization endpoint that signs the user in without
+// asking anything, and a token endpoint that returns an RS256 signed ID token.
+// cmd/mockidp serves it on a port, tests mount Handler on an httptest.Server.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Identity is the user the provider signs in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Name          string
+}
+
+// authorization is an issued authorization code waiting to be redeemed.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	expiresAt     time.Time
+}
+
+// Provider holds the signing key and the outstanding authorization codes.
+// Issuer and Identity may be changed between logins, under no concurrent request.
+type Provider struct {
+	Issuer   string
+	ClientID string
+	Identity Identity
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// New creates a Provider with a new RSA signing key.
+func New(issuer string, clientID string, identity Identity) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer:   strings.TrimRight(issuer, "/"),
+		ClientID: clientID,
+		Identity: identity,
+		key:      key,
+		codes:    make(map[string]authorization),
+	}, nil
+}
+
+// Handler serves the provider's endpoints.
+func (p *Provider) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	return mux
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// oauthError writes an OAuth 2.0 error response.
+func oauthError(w http.ResponseWriter, code string, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize signs the user in immediately and redirects back with an authorization code.
+// A login_hint=<email> parameter signs in another identity with that email.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "only response_type=code with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	email := p.Identity.Email
+	if hint := q.Get("login_hint"); hint != "" {
+		email = hint
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:      p.ClientID,
+		redirectURI:   redirectURI.String(),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		email:         email,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	back := redirectURI.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token redeems an authorization code after checking the PKCE verifier.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(auth.expiresAt) {
+		oauthError(w, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		oauthError(w, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.codeChallenge {
+		oauthError(w, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	id := p.Identity
+	if auth.email != id.Email {
+		// Identities selected with login_hint get their own subject.
+		id.Subject = "hint:" + auth.email
+		id.Username, _, _ = strings.Cut(auth.email, "@")
+		id.Email = auth.email
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                p.Issuer,
+		"sub":                id.Subject,
+		"aud":                auth.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              auth.nonce,
+		"email":              id.Email,
+		"email_verified":     id.EmailVerified,
+		"preferred_username": id.Username,
+		"name":               id.Name,
+	})
+	token.Header["kid"] = "mock"
+
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		oauthError(w, "server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,100 @@
+package throttle
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the counters in memory. It is only correct when a single server node handles the logins.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*Entry)}
+}
+
+// Get returns a copy of the counter of key.
+func (m *MemoryStore) Get(ctx context.Context, key string) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		return *e, nil
+	}
+	return Entry{Key: key}, nil
+}
+
+// AddFailure counts a failure, starting over when the previous one is older than window.
+func (m *MemoryStore) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		e = &Entry{Key: key}
+		m.entries[key] = e
+	}
+	if e.LastFailure.Before(now.Add(-window)) {
+		e.Failures = 0
+	}
+	e.Failures++
+	e.LastFailure = now
+	return *e, nil
+}
+
+// Lock locks key until the given time.
+func (m *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		e = &Entry{Key: key}
+		m.entries[key] = e
+	}
+	e.LockedUntil = &until
+	return nil
+}
+
+// Reset forgets key.
+func (m *MemoryStore) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// Locked lists the keys locked at now, the longest lock first.
+func (m *MemoryStore) Locked(ctx context.Context, now time.Time) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	locked := make([]Entry, 0)
+	for _, e := range m.entries {
+		if e.LockedUntil != nil && e.LockedUntil.After(now) {
+			locked = append(locked, *e)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].LockedUntil.After(*locked[j].LockedUntil) })
+	return locked, nil
+}
+
+// Prune deletes the counters last failed before the given time that are not locked anymore.
+func (m *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, e := range m.entries {
+		if e.LastFailure.Before(before) && (e.LockedUntil == nil || e.LockedUntil.Before(now)) {
+			delete(m.entries, key)
+		}
+	}
+	return nil
+}
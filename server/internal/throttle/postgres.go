@@ -0,0 +1,93 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore keeps the counters in the login_attempts table, so every server node sees the same failures.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a store on the given database.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Get returns the counter of key.
+func (p *PostgresStore) Get(ctx context.Context, key string) (Entry, error) {
+	e := Entry{}
+	query := "SELECT key, failures, last_failure, locked_until FROM login_attempts WHERE key = $1"
+
+	err := p.db.QueryRowContext(ctx, query, key).Scan(&e.Key, &e.Failures, &e.LastFailure, &e.LockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{Key: key}, nil
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// AddFailure counts a failure in a single upsert, so concurrent failures on several nodes are all counted.
+func (p *PostgresStore) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error) {
+	e := Entry{}
+	query := "INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 1, $2) " +
+		"ON CONFLICT (key) DO UPDATE SET " +
+		"failures = CASE WHEN login_attempts.last_failure < $3 THEN 1 ELSE login_attempts.failures + 1 END, " +
+		"last_failure = $2 " +
+		"returning key, failures, last_failure, locked_until"
+
+	err := p.db.QueryRowContext(ctx, query, key, now, now.Add(-window)).Scan(&e.Key, &e.Failures, &e.LastFailure, &e.LockedUntil)
+	if err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// Lock locks key until the given time.
+func (p *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	query := "INSERT INTO login_attempts (key, failures, last_failure, locked_until) VALUES ($1, 0, now(), $2) " +
+		"ON CONFLICT (key) DO UPDATE SET locked_until = $2"
+
+	_, err := p.db.ExecContext(ctx, query, key, until)
+	return err
+}
+
+// Reset forgets key.
+func (p *PostgresStore) Reset(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
+
+// Locked lists the keys locked at now, the longest lock first.
+func (p *PostgresStore) Locked(ctx context.Context, now time.Time) ([]Entry, error) {
+	query := "SELECT key, failures, last_failure, locked_until FROM login_attempts WHERE locked_until > $1 ORDER BY locked_until DESC"
+
+	rows, err := p.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locked := make([]Entry, 0)
+	for rows.Next() {
+		e := Entry{}
+		if err := rows.Scan(&e.Key, &e.Failures, &e.LastFailure, &e.LockedUntil); err != nil {
+			return nil, err
+		}
+		locked = append(locked, e)
+	}
+	return locked, rows.Err()
+}
+
+// Prune deletes the counters last failed before the given time that are not locked anymore.
+func (p *PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	query := "DELETE FROM login_attempts WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < now())"
+
+	_, err := p.db.ExecContext(ctx, query, before)
+	return err
+}
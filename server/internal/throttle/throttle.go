@@ -0,0 +1,277 @@
+// Package throttle slows down and locks out repeated failed attempts, such as password guessing on the login.
+//
+// Failures are counted per key, e.g. one key for the account and one for the client IP.
+// A Guard applies the backoff and lockout rules, a Store keeps the counters:
+// MemoryStore for a single server node, PostgresStore to share them between nodes.
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"server/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of keys, each with its own rule.
+const (
+	KindAccount   = "account"
+	KindIP        = "ip"
+	KindMagicLink = "magic"
+	KindMail      = "mail"
+	KindMailIP    = "mail_ip"
+)
+
+// pruneInterval is how often the Guard deletes counters that no longer matter.
+const pruneInterval = 10 * time.Minute
+
+// ErrLimited is matched by errors.Is for every *LimitedError.
+var ErrLimited = errors.New("too many failed attempts")
+
+// LimitedError is returned while a key has to wait before its next attempt.
+type LimitedError struct {
+	Key        string
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrLimited, e.RetryAfter.Round(time.Second))
+}
+
+// Is makes errors.Is(err, ErrLimited) report true.
+func (e *LimitedError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+// Entry is the failure counter of one key.
+type Entry struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// Store keeps the failure counters.
+type Store interface {
+	// Get returns the counter of a key, a zero Entry when it has none.
+	Get(ctx context.Context, key string) (Entry, error)
+	// AddFailure counts a failure at now. A counter whose last failure is older than window starts over.
+	AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Entry, error)
+	// Lock locks a key until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets the counter and lock of a key.
+	Reset(ctx context.Context, key string) error
+	// Locked lists the keys that are locked at now.
+	Locked(ctx context.Context, now time.Time) ([]Entry, error)
+	// Prune deletes the counters whose last failure is before the given time and that are not locked anymore.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// Guard applies the configured rules to the counters of a Store.
+type Guard struct {
+	store Store
+	rules map[string]config.ThrottleRule
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// New creates the Guard configured by cfg. The "postgres" store needs db, the "memory" store ignores it.
+func New(cfg config.ThrottleConfig, db *sql.DB) (*Guard, error) {
+	var store Store
+	switch cfg.Store {
+	case "", "memory":
+		store = NewMemoryStore()
+	case "postgres":
+		if db == nil {
+			return nil, fmt.Errorf("throttle: the postgres store needs a database")
+		}
+		store = NewPostgresStore(db)
+	default:
+		return nil, fmt.Errorf("unknown throttle store %q", cfg.Store)
+	}
+
+	return NewGuard(store, Rules(cfg)), nil
+}
+
+// Rules returns the rule of every kind of key configured in cfg.
+func Rules(cfg config.ThrottleConfig) map[string]config.ThrottleRule {
+	return map[string]config.ThrottleRule{
+		KindAccount:   cfg.Account,
+		KindIP:        cfg.IP,
+		KindMagicLink: cfg.MagicLink,
+		KindMail:      cfg.Mail,
+		KindMailIP:    cfg.MailIP,
+	}
+}
+
+// NewGuard creates a Guard with a rule per kind of key.
+func NewGuard(store Store, rules map[string]config.ThrottleRule) *Guard {
+	return &Guard{store: store, rules: rules, lastPruned: time.Now()}
+}
+
+// AccountKey returns the key of an account, identified by its login name or email.
+func AccountKey(login string) string {
+	return KindAccount + ":" + strings.ToLower(strings.TrimSpace(login))
+}
+
+// MagicLinkKey returns the key counting the login links requested for an email address.
+// Every request counts as an attempt: the key limits how many emails are sent, not failed logins.
+func MagicLinkKey(email string) string {
+	return KindMagicLink + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// MailKey returns the key counting the emails of one purpose, such as password resets, requested for an address.
+// Like MagicLinkKey, every request counts as an attempt.
+func MailKey(purpose string, email string) string {
+	return KindMail + ":" + purpose + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// MailIPKey returns the key counting the emails requested from a client IP, for any address.
+func MailIPKey(ip string) string {
+	return KindMailIP + ":" + ip
+}
+
+// IPKey returns the key of a client IP.
+func IPKey(ip string) string {
+	return KindIP + ":" + ip
+}
+
+// Check returns a *LimitedError when any of the keys has to wait before its next attempt.
+// Keys without a rule, such as an IPKey of an unknown address, are ignored.
+func (g *Guard) Check(ctx context.Context, keys ...string) error {
+	now := time.Now()
+
+	var limited *LimitedError
+	for _, key := range keys {
+		rule, ok := g.rule(key)
+		if !ok {
+			continue
+		}
+
+		entry, err := g.store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		until, locked := blockedUntil(rule, entry)
+		if wait := until.Sub(now); wait > 0 && (limited == nil || wait > limited.RetryAfter) {
+			limited = &LimitedError{Key: key, RetryAfter: wait, Locked: locked}
+		}
+	}
+
+	if limited != nil {
+		return limited
+	}
+	return nil
+}
+
+// Failure counts a failed attempt for every key and locks the keys that reached their lockout threshold.
+func (g *Guard) Failure(ctx context.Context, keys ...string) error {
+	now := time.Now()
+
+	for _, key := range keys {
+		rule, ok := g.rule(key)
+		if !ok {
+			continue
+		}
+
+		entry, err := g.store.AddFailure(ctx, key, now, rule.Window.Duration)
+		if err != nil {
+			return err
+		}
+
+		alreadyLocked := entry.LockedUntil != nil && now.Before(*entry.LockedUntil)
+		if rule.LockoutAfter > 0 && entry.Failures >= rule.LockoutAfter && !alreadyLocked {
+			until := now.Add(rule.LockoutDuration.Duration)
+			if err := g.store.Lock(ctx, key, until); err != nil {
+				return err
+			}
+			log.Printf("throttle: locked %s until %s after %d failed attempts", key, until.Format(time.RFC3339), entry.Failures)
+		}
+	}
+
+	g.maybePrune(ctx, now)
+	return nil
+}
+
+// Success forgets the failures of the given keys, typically the account after a completed login.
+// The IP key should not be reset: one valid account would otherwise lift the limit on guessing others.
+func (g *Guard) Success(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := g.store.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lockouts lists the keys that are currently locked.
+func (g *Guard) Lockouts(ctx context.Context) ([]Entry, error) {
+	return g.store.Locked(ctx, time.Now())
+}
+
+// Unlock lifts the lock and forgets the failures of a key.
+func (g *Guard) Unlock(ctx context.Context, key string) error {
+	if err := g.store.Reset(ctx, key); err != nil {
+		return err
+	}
+	log.Printf("throttle: unlocked %s", key)
+	return nil
+}
+
+// rule returns the rule for the kind of key.
+func (g *Guard) rule(key string) (config.ThrottleRule, bool) {
+	kind, value, ok := strings.Cut(key, ":")
+	if !ok || value == "" {
+		return config.ThrottleRule{}, false
+	}
+	rule, ok := g.rules[kind]
+	return rule, ok
+}
+
+// maybePrune deletes stale counters at most once per pruneInterval.
+func (g *Guard) maybePrune(ctx context.Context, now time.Time) {
+	g.mu.Lock()
+	if now.Sub(g.lastPruned) < pruneInterval {
+		g.mu.Unlock()
+		return
+	}
+	g.lastPruned = now
+	g.mu.Unlock()
+
+	var window time.Duration
+	for _, rule := range g.rules {
+		if rule.Window.Duration > window {
+			window = rule.Window.Duration
+		}
+	}
+	if err := g.store.Prune(ctx, now.Add(-window)); err != nil {
+		log.Printf("throttle: prune: %v", err)
+	}
+}
+
+// blockedUntil returns when the key may try again, and whether that is because of a lockout.
+// Up to FreeAttempts failures cost nothing, then every failure doubles the delay from BaseDelay up to MaxDelay.
+func blockedUntil(rule config.ThrottleRule, entry Entry) (time.Time, bool) {
+	var until time.Time
+	if n := entry.Failures - rule.FreeAttempts; n > 0 && rule.BaseDelay.Duration > 0 {
+		delay := rule.BaseDelay.Duration
+		for i := 1; i < n && delay < rule.MaxDelay.Duration; i++ {
+			delay *= 2
+		}
+		if rule.MaxDelay.Duration > 0 && delay > rule.MaxDelay.Duration {
+			delay = rule.MaxDelay.Duration
+		}
+		until = entry.LastFailure.Add(delay)
+	}
+
+	if entry.LockedUntil != nil && entry.LockedUntil.After(until) {
+		return *entry.LockedUntil, true
+	}
+	return until, false
+}
@@ -0,0 +1,143 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"testing"
+	"time"
+)
+
+var testRule = config.ThrottleRule{
+	FreeAttempts:    3,
+	BaseDelay:       config.Duration{Duration: time.Second},
+	MaxDelay:        config.Duration{Duration: 10 * time.Second},
+	LockoutAfter:    10,
+	LockoutDuration: config.Duration{Duration: 15 * time.Minute},
+	Window:          config.Duration{Duration: 15 * time.Minute},
+}
+
+func TestBlockedUntil(t *testing.T) {
+	last := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	lockedUntil := last.Add(15 * time.Minute)
+	shortLock := last.Add(time.Second)
+
+	tests := []struct {
+		name       string
+		rule       config.ThrottleRule
+		entry      Entry
+		want       time.Time
+		wantLocked bool
+	}{
+		{name: "no failures", rule: testRule, entry: Entry{}},
+		{name: "free attempts", rule: testRule, entry: Entry{Failures: 3, LastFailure: last}},
+		{name: "first delayed attempt", rule: testRule, entry: Entry{Failures: 4, LastFailure: last}, want: last.Add(time.Second)},
+		{name: "delay doubles", rule: testRule, entry: Entry{Failures: 6, LastFailure: last}, want: last.Add(4 * time.Second)},
+		{name: "delay is capped", rule: testRule, entry: Entry{Failures: 9, LastFailure: last}, want: last.Add(10 * time.Second)},
+		{
+			name:       "lockout",
+			rule:       testRule,
+			entry:      Entry{Failures: 10, LastFailure: last, LockedUntil: &lockedUntil},
+			want:       lockedUntil,
+			wantLocked: true,
+		},
+		{
+			name:  "lockout shorter than the delay",
+			rule:  testRule,
+			entry: Entry{Failures: 9, LastFailure: last, LockedUntil: &shortLock},
+			want:  last.Add(10 * time.Second),
+		},
+		{
+			name:  "no base delay",
+			rule:  config.ThrottleRule{FreeAttempts: 1},
+			entry: Entry{Failures: 5, LastFailure: last},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, locked := blockedUntil(tt.rule, tt.entry)
+			if !got.Equal(tt.want) || locked != tt.wantLocked {
+				t.Fatalf("blockedUntil = %v, %v, want %v, %v", got, locked, tt.want, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	account := AccountKey(" Jane@Example.com ")
+	ip := IPKey("192.0.2.1")
+
+	tests := []struct {
+		name       string
+		failures   int
+		keys       []string
+		success    bool
+		wantErr    bool
+		wantLocked bool
+	}{
+		{name: "free attempts", failures: 3, keys: []string{account}},
+		{name: "delay after the free attempts", failures: 4, keys: []string{account}, wantErr: true},
+		{name: "lockout", failures: 10, keys: []string{account}, wantErr: true, wantLocked: true},
+		{name: "success forgets failures", failures: 10, keys: []string{account}, success: true},
+		{name: "each key has its own rule", failures: 4, keys: []string{ip}},
+		{name: "the most limited key wins", failures: 4, keys: []string{ip, account}, wantErr: true},
+		{name: "keys without a rule are ignored", failures: 20, keys: []string{"other:x", IPKey("")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipRule := testRule
+			ipRule.FreeAttempts = 10
+			g := NewGuard(NewMemoryStore(), map[string]config.ThrottleRule{KindAccount: testRule, KindIP: ipRule})
+
+			for i := 0; i < tt.failures; i++ {
+				if err := g.Failure(ctx, tt.keys...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.success {
+				if err := g.Success(ctx, tt.keys...); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := g.Check(ctx, tt.keys...)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Check = %v, want nil", err)
+				}
+				return
+			}
+			var limited *LimitedError
+			if !errors.As(err, &limited) || !errors.Is(err, ErrLimited) {
+				t.Fatalf("Check = %v, want a *LimitedError", err)
+			}
+			if limited.Key != account || limited.Locked != tt.wantLocked || limited.RetryAfter <= 0 {
+				t.Fatalf("Check = %+v", limited)
+			}
+		})
+	}
+}
+
+func TestGuardUnlock(t *testing.T) {
+	ctx := context.Background()
+	g := NewGuard(NewMemoryStore(), map[string]config.ThrottleRule{KindAccount: testRule})
+	key := AccountKey("jane@example.com")
+
+	for i := 0; i < testRule.LockoutAfter; i++ {
+		if err := g.Failure(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lockouts, err := g.Lockouts(ctx)
+	if err != nil || len(lockouts) != 1 || lockouts[0].Key != "account:jane@example.com" {
+		t.Fatalf("Lockouts = %+v, %v", lockouts, err)
+	}
+
+	if err := g.Unlock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check(ctx, key); err != nil {
+		t.Fatalf("Check after Unlock = %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"server/internal/audit"
+	"server/internal/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix starts every personal access token, so they are told apart from session tokens
+// and secret scanners can recognize them.
+const AccessTokenPrefix = "gcp_"
+
+// Scopes of personal access tokens, checked by RequireScope.
+const (
+	ScopeProfileRead   = "profile:read"
+	ScopeProfileWrite  = "profile:write"
+	ScopeUsersRead     = "users:read"
+	ScopeRoomsRead     = "rooms:read"
+	ScopeRoomsWrite    = "rooms:write"
+	ScopeMessagesWrite = "messages:write"
+)
+
+const (
+	// accessTokenSessionPrefix prefixes the SessionID of claims built from a personal access token,
+	// so revoking the token can close its WebSockets like a session.
+	accessTokenSessionPrefix = "pat:"
+
+	// defaultAccessTokenTTL is the lifetime of a personal access token created without expires_in_days.
+	defaultAccessTokenTTL = 90 * 24 * time.Hour
+
+	// accessTokenTouchInterval bounds how often the last use of a token is written to the database.
+	accessTokenTouchInterval = time.Minute
+)
+
+// ListAccessTokens returns the personal access tokens of the logged in user.
+func (s *service) ListAccessTokens(c context.Context, claims *MyJWTClaims) ([]AccessToken, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return s.Repository.ListAccessTokens(ctx, userID)
+}
+
+// CreateAccessToken creates a personal access token with the requested scopes.
+// Only its hash is stored, the value is returned once.
+func (s *service) CreateAccessToken(c context.Context, claims *MyJWTClaims, req *CreateAccessTokenReq) (*CreateAccessTokenRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := defaultAccessTokenTTL
+	if req.ExpiresInDays > 0 {
+		ttl = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+
+	secret, err := util.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	value := AccessTokenPrefix + secret
+
+	token, err := s.Repository.CreateAccessToken(ctx, &AccessToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: util.HashToken(value),
+		Scopes:    dedupe(req.Scopes),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.auditTokenCreated(ctx, audit.TypeAccessTokenCreated, userID, token)
+	return &CreateAccessTokenRes{AccessToken: *token, Token: value}, nil
+}
+
+// DeleteAccessToken deletes a personal access token of the logged in user and closes its WebSockets.
+func (s *service) DeleteAccessToken(c context.Context, claims *MyJWTClaims, id int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.DeleteAccessToken(ctx, userID, id); err != nil {
+		return err
+	}
+	s.recordAudit(ctx, audit.Event{
+		Type:    audit.TypeAccessTokenDeleted,
+		UserID:  &userID,
+		Success: true,
+		Details: map[string]string{"token_id": strconv.FormatInt(id, 10)},
+	})
+
+	if s.terminator != nil {
+		s.terminator.TerminateSessions(accessTokenSessionPrefix + strconv.FormatInt(id, 10))
+	}
+	return nil
+}
+
+// parseAccessToken builds the claims of a personal access token. The role and email status are read
+// from the user on every request, so they follow changes at once.
+func (s *service) parseAccessToken(ctx context.Context, value string) (*MyJWTClaims, error) {
+	token, err := s.Repository.GetAccessToken(ctx, util.HashToken(value))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotAuthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.After(token.ExpiresAt) {
+		return nil, ErrNotAuthenticated
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, token.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrNotAuthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DeleteAfter != nil {
+		return nil, ErrNotAuthenticated
+	}
+	// Bots stop working with their owner's account, until the owner cancels its deletion by logging in.
+	if user.IsBot && user.BotOwnerID != nil {
+		owner, err := s.Repository.GetUserByID(ctx, *user.BotOwnerID)
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrNotAuthenticated
+		}
+		if err != nil {
+			return nil, err
+		}
+		if owner.DeleteAfter != nil {
+			return nil, ErrNotAuthenticated
+		}
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > accessTokenTouchInterval {
+		if err := s.Repository.TouchAccessToken(ctx, token.ID); err != nil {
+			log.Printf("access token %d: record last use: %v", token.ID, err)
+		}
+	}
+
+	return &MyJWTClaims{
+		ID:            strconv.FormatInt(user.ID, 10),
+		Username:      user.Username,
+		SessionID:     accessTokenSessionPrefix + strconv.FormatInt(token.ID, 10),
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		Scopes:        token.Scopes,
+		Bot:           user.IsBot,
+	}, nil
+}
+
+// dedupe returns the distinct values of list in their first order.
+func dedupe(list []string) []string {
+	out := make([]string, 0, len(list))
+	for _, v := range list {
+		if !contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
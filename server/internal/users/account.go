@@ -0,0 +1,215 @@
+package users
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"server/internal/mailer"
+	"strconv"
+	"time"
+)
+
+// UserContent keeps content authored by users outside the users table, such as the chat rooms.
+// It is implemented by ws.Hub so account deletion reaches the live chat too.
+type UserContent interface {
+	// RoomsCreatedBy lists the rooms created by the user.
+	RoomsCreatedBy(userID string) []ExportedRoom
+	// TerminateUser closes every live connection of the user.
+	TerminateUser(userID string)
+	// AnonymizeUser detaches the user from the content they authored, which stays for the other users.
+	AnonymizeUser(userID string)
+}
+
+// ExportedRoom is a chat room in a personal data export.
+type ExportedRoom struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// AccountExport is the personal data kept about a user.
+// Chat messages are not part of it because the server does not store them.
+type AccountExport struct {
+	ExportedAt time.Time      `json:"exported_at"`
+	Account    MeRes          `json:"account"`
+	Identities []Identity     `json:"identities"`
+	Rooms      []ExportedRoom `json:"rooms"`
+}
+
+// WriteZip writes the export as a ZIP archive with one JSON file per part.
+func (e *AccountExport) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	for _, part := range []struct {
+		name string
+		v    any
+	}{
+		{"account.json", e.Account},
+		{"identities.json", e.Identities},
+		{"rooms.json", e.Rooms},
+	} {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: part.name, Method: zip.Deflate, Modified: e.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(part.v); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// DeleteAccount re-authenticates the user and schedules the account for deletion after the grace period.
+// Every session is logged out at once; logging in again before the deletion is due cancels it.
+func (s *service) DeleteAccount(c context.Context, claims *MyJWTClaims, req *DeleteAccountReq) (*DeleteAccountRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reauthenticate(ctx, user, req.CurrentPassword, req.Code, req.RecoveryCode); err != nil {
+		return nil, err
+	}
+
+	return s.scheduleDeletion(ctx, user, false)
+}
+
+// scheduleDeletion marks the account for deletion after the grace period, logs out its sessions, disconnects
+// its bots and tells its owner.
+// byAdmin is set when an administrator deleted the account, which its owner cannot cancel by logging in.
+func (s *service) scheduleDeletion(ctx context.Context, user User, byAdmin bool) (*DeleteAccountRes, error) {
+	deleteAfter := time.Now().Add(s.deletionGrace).UTC()
+	if err := s.Repository.ScheduleDeletion(ctx, user.ID, deleteAfter, byAdmin); err != nil {
+		return nil, err
+	}
+	if err := s.revokeAllSessions(ctx, user.ID, RevokeReasonAccountDeleted); err != nil {
+		return nil, err
+	}
+	if s.content != nil {
+		s.content.TerminateUser(strconv.FormatInt(user.ID, 10))
+		// The tokens of the user's bots are refused until the deletion is cancelled, see parseAccessToken.
+		// Their open connections are closed here, as a WebSocket is only authenticated when it opens.
+		bots, err := s.Repository.ListBots(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, bot := range bots {
+			s.content.TerminateUser(strconv.FormatInt(bot.ID, 10))
+		}
+	}
+
+	// Bots have no email address, an administrator deleting one tells nobody.
+	switch {
+	case user.IsBot:
+	case byAdmin:
+		s.sendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Your account was deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nAn administrator deleted your account. It will be deleted for good after %s.\n",
+				user.Username, deleteAfter.Format(time.RFC1123)),
+		})
+	default:
+		s.sendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Your account will be deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nYour account will be deleted for good after %s. Log in before then if you want to keep it.\n",
+				user.Username, deleteAfter.Format(time.RFC1123)),
+		})
+	}
+
+	return &DeleteAccountRes{DeleteAfter: deleteAfter}, nil
+}
+
+// cancelDeletion keeps an account that was scheduled for deletion, called when its owner logs in again.
+// Deletions by an administrator are not cancelled, those accounts cannot log in (see refuseDeletedAccount).
+func (s *service) cancelDeletion(ctx context.Context, user User) error {
+	if err := s.Repository.CancelDeletion(ctx, user.ID); err != nil {
+		return err
+	}
+	log.Printf("account: deletion of user %d cancelled by login", user.ID)
+	return nil
+}
+
+// refuseDeletedAccount returns ErrAccountDeleted for an account an administrator deleted, and records
+// the refused login with the given method.
+func (s *service) refuseDeletedAccount(ctx context.Context, user User, method string) error {
+	if !user.DeletedByAdmin {
+		return nil
+	}
+	s.auditLoginFailed(ctx, user.Email, &user, method, ErrAccountDeleted)
+	return ErrAccountDeleted
+}
+
+// ExportAccount collects the account, linked identities and created rooms of the logged in user.
+func (s *service) ExportAccount(c context.Context, claims *MyJWTClaims) (*AccountExport, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := s.Repository.ListIdentities(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := []ExportedRoom{}
+	if s.content != nil {
+		rooms = append(rooms, s.content.RoomsCreatedBy(claims.ID)...)
+	}
+
+	return &AccountExport{
+		ExportedAt: time.Now().UTC(),
+		Account:    *newMeRes(user),
+		Identities: identities,
+		Rooms:      rooms,
+	}, nil
+}
+
+// PurgeDeletedAccounts deletes the accounts whose grace period is over, anonymizes their chat content
+// and blanks their email, IP and user agent in the audit log.
+func (s *service) PurgeDeletedAccounts(c context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	purged, err := s.Repository.PurgeDeletedUsers(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, user := range purged {
+		if s.content != nil {
+			userID := strconv.FormatInt(user.ID, 10)
+			s.content.TerminateUser(userID)
+			s.content.AnonymizeUser(userID)
+		}
+		s.redactAudit(ctx, user)
+		log.Printf("account: purged user %d", user.ID)
+	}
+	return len(purged), nil
+}
+
+// RunAccountPurge calls PurgeDeletedAccounts every interval until ctx is done.
+func RunAccountPurge(ctx context.Context, svc Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := svc.PurgeDeletedAccounts(ctx); err != nil {
+			log.Printf("account: purge deleted accounts: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"server/internal/throttle"
+	"strconv"
+)
+
+// BootstrapAdmins gives the admin role to the accounts with the given email addresses.
+// Addresses without an account are logged and skipped, they can be registered and bootstrapped on the next start.
+func (s *service) BootstrapAdmins(c context.Context, emails []string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	for _, email := range emails {
+		err := s.Repository.SetRoleByEmail(ctx, email, RoleAdmin)
+		if errors.Is(err, ErrUserNotFound) {
+			log.Printf("admin bootstrap: no account for %s", email)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUser returns the account details of any user.
+func (s *service) GetUser(c context.Context, userID int64) (*AdminUserRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminUserRes{MeRes: *newMeRes(user)}, nil
+}
+
+// SetRole changes the role of another user. Administrators cannot change their own role,
+// which also keeps the last administrator from locking everyone out.
+func (s *service) SetRole(c context.Context, claims *MyJWTClaims, userID int64, req *SetRoleReq) (*AdminUserRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if !ValidRole(req.Role) {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidRequest, req.Role)
+	}
+	if strconv.FormatInt(userID, 10) == claims.ID {
+		return nil, fmt.Errorf("%w: you cannot change your own role", ErrForbidden)
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role != req.Role {
+		if err := s.Repository.SetRole(ctx, userID, req.Role); err != nil {
+			return nil, err
+		}
+		// Access tokens carry the role, the user logs in again to get one with the new role.
+		if err := s.revokeAllSessions(ctx, userID, RevokeReasonRoleChange); err != nil {
+			return nil, err
+		}
+		log.Printf("admin: user %s changed the role of user %d from %s to %s", claims.ID, userID, user.Role, req.Role)
+		user.Role = req.Role
+	}
+	return &AdminUserRes{MeRes: *newMeRes(user)}, nil
+}
+
+// DeleteUser schedules the deletion of another user's account with the usual grace period.
+// Unlike a deletion by its owner, logging in does not cancel it: the account cannot log in any more.
+func (s *service) DeleteUser(c context.Context, claims *MyJWTClaims, userID int64) (*DeleteAccountRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if strconv.FormatInt(userID, 10) == claims.ID {
+		return nil, fmt.Errorf("%w: delete your own account with DELETE /users/me", ErrForbidden)
+	}
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("admin: user %s deleted the account of user %d", claims.ID, userID)
+	return s.scheduleDeletion(ctx, user, true)
+}
+
+// ListLockouts lists the login throttle keys that are currently locked.
+func (s *service) ListLockouts(c context.Context) ([]throttle.Entry, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	lockouts, err := s.guard.Lockouts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lockouts == nil {
+		lockouts = []throttle.Entry{}
+	}
+	return lockouts, nil
+}
+
+// Unlock lifts the lockout of a login throttle key, such as "account:jane@example.com".
+func (s *service) Unlock(c context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.guard.Unlock(ctx, key)
+}
@@ -0,0 +1,163 @@
+package users
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUser method
+// It returns the account details of the user in the ":id" path parameter, for administrators.
+func (h *Handler) GetUser(c *gin.Context) {
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.Service.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// SetRole method
+// It changes the role of the user in the ":id" path parameter and logs out their sessions.
+func (h *Handler) SetRole(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	var req SetRoleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.SetRole(c.Request.Context(), claims, userID, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// RevokeSessions method
+// It logs out every session of the user in the ":id" path parameter.
+func (h *Handler) RevokeSessions(c *gin.Context) {
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.RevokeUserSessions(c.Request.Context(), userID, RevokeReasonAdmin); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions Revoked Successfully"})
+}
+
+// DeleteUser method
+// It schedules the deletion of the account in the ":id" path parameter.
+func (h *Handler) DeleteUser(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.Service.DeleteUser(c.Request.Context(), claims, userID)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, res)
+}
+
+// ListLockouts method
+// It lists the accounts and IPs that are locked out of the login.
+func (h *Handler) ListLockouts(c *gin.Context) {
+	res, err := h.Service.ListLockouts(c.Request.Context())
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// Unlock method
+// It lifts the lockout of the throttle key in the "key" query parameter, e.g. "account:jane@example.com".
+func (h *Handler) Unlock(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		WriteError(c, InvalidRequest(errors.New("key is required")))
+		return
+	}
+
+	if err := h.Service.Unlock(c.Request.Context(), key); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unlocked Successfully"})
+}
+
+// ListAuditEvents method
+// It returns a page of the authentication audit log, newest first, filtered by the query parameters.
+func (h *Handler) ListAuditEvents(c *gin.Context) {
+	var req ListAuditEventsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.ListAuditEvents(c.Request.Context(), &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// ExportAuditEvents method
+// It downloads the audit events matching the same filters as ListAuditEvents as a JSON Lines file.
+func (h *Handler) ExportAuditEvents(c *gin.Context) {
+	var req ListAuditEventsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	if err := h.Service.ExportAuditEvents(c.Request.Context(), &req, c.Writer); err != nil {
+		if !c.Writer.Written() {
+			// Nothing was streamed yet, the error can still be answered as JSON.
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Del("Content-Type")
+			WriteError(c, err)
+			return
+		}
+		log.Printf("%s %s: %v", c.Request.Method, c.FullPath(), err)
+	}
+}
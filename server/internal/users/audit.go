@@ -0,0 +1,149 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"server/internal/audit"
+	"strconv"
+	"time"
+)
+
+// Methods of the login recorded in the details of audit.TypeLogin events.
+const (
+	loginMethodPassword  = "password"
+	loginMethodTwoFactor = "two_factor"
+	loginMethodOIDC      = "oidc"
+	// loginMethodReauthentication is recorded for refused confirmations of a sensitive change, such as
+	// a password change, by a user who is already logged in.
+	loginMethodReauthentication = "reauthentication"
+)
+
+// maxAuditEventsPerPage bounds the page size of ListAuditEvents.
+const maxAuditEventsPerPage = 200
+
+// ListAuditEvents returns a page of the audit log, newest first.
+func (s *service) ListAuditEvents(c context.Context, req *ListAuditEventsReq) (*AuditEventsRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if s.auditLog == nil {
+		return &AuditEventsRes{Events: []audit.Event{}}, nil
+	}
+	filter, err := req.filter()
+	if err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 || filter.Limit > maxAuditEventsPerPage {
+		filter.Limit = maxAuditEventsPerPage
+	}
+
+	events, err := s.auditLog.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	res := &AuditEventsRes{Events: events}
+	if len(events) == filter.Limit {
+		res.NextCursor = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+	return res, nil
+}
+
+// ExportAuditEvents writes the events matching the filters of req to w as JSON Lines. The page size is ignored.
+// It is not bounded by the service timeout, as a large export takes as long as the client needs to read it.
+func (s *service) ExportAuditEvents(c context.Context, req *ListAuditEventsReq, w io.Writer) error {
+	if s.auditLog == nil {
+		return nil
+	}
+	filter, err := req.filter()
+	if err != nil {
+		return err
+	}
+	return s.auditLog.Export(c, filter, w)
+}
+
+// filter converts the query of the request into an audit.Filter.
+func (r *ListAuditEventsReq) filter() (audit.Filter, error) {
+	f := audit.Filter{
+		Type:    r.Type,
+		UserID:  r.UserID,
+		Email:   r.Email,
+		IP:      r.IP,
+		Success: r.Success,
+		Since:   r.Since,
+		Until:   r.Until,
+		Limit:   r.Limit,
+	}
+	if r.Cursor != "" {
+		before, err := strconv.ParseInt(r.Cursor, 10, 64)
+		if err != nil || before <= 0 {
+			return audit.Filter{}, fmt.Errorf("%w: invalid cursor", ErrInvalidRequest)
+		}
+		f.Before = before
+	}
+	return f, nil
+}
+
+// recordAudit appends an event to the audit log, if one is set. Failures are only logged:
+// the action being recorded already happened.
+func (s *service) recordAudit(ctx context.Context, e audit.Event) {
+	if s.auditLog == nil {
+		return
+	}
+	if err := s.auditLog.Record(ctx, e); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// redactAudit blanks the personal data of a purged user in the audit log, if one is set.
+// The events stay, with the user ID only. Failures are logged with the ID, so the redaction can be done by hand.
+func (s *service) redactAudit(ctx context.Context, user User) {
+	if s.auditLog == nil {
+		return
+	}
+	if err := s.auditLog.Redact(ctx, user.ID, user.Email); err != nil {
+		log.Printf("audit: redact user %d: %v", user.ID, err)
+	}
+}
+
+// auditUserEvent records a successful event of user.
+func (s *service) auditUserEvent(ctx context.Context, eventType string, user User, details map[string]string) {
+	s.recordAudit(ctx, audit.Event{
+		Type:    eventType,
+		UserID:  &user.ID,
+		Email:   user.Email,
+		Success: true,
+		Details: details,
+	})
+}
+
+// auditLoginFailed records a refused login with the error code returned to the client as reason.
+// user is nil when the email is unknown.
+func (s *service) auditLoginFailed(ctx context.Context, email string, user *User, method string, err error) {
+	_, res := errorResponse(err)
+	e := audit.Event{
+		Type:    audit.TypeLogin,
+		Email:   email,
+		Reason:  res.Code,
+		Details: map[string]string{"method": method},
+	}
+	if user != nil {
+		e.UserID = &user.ID
+	}
+	s.recordAudit(ctx, e)
+}
+
+// auditTokenCreated records the creation of an access token by userID. The tokens of bots are recorded
+// as created by their owner, with the bot in the details.
+func (s *service) auditTokenCreated(ctx context.Context, eventType string, userID int64, token *AccessToken) {
+	details := map[string]string{
+		"token_id":   strconv.FormatInt(token.ID, 10),
+		"name":       token.Name,
+		"expires_at": token.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if token.UserID != userID {
+		details["bot_id"] = strconv.FormatInt(token.UserID, 10)
+	}
+	s.recordAudit(ctx, audit.Event{Type: eventType, UserID: &userID, Success: true, Details: details})
+}
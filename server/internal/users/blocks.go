@@ -0,0 +1,118 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// Kinds of relations stored in the user_blocks table.
+const (
+	// BlockKindBlock hides the messages of the blocked user from the blocker, the server does not deliver them.
+	BlockKindBlock = "block"
+	// BlockKindMute only asks the clients of the muter to hide the muted user, the server still delivers everything.
+	BlockKindMute = "mute"
+)
+
+// errBlockSelf is returned when users try to block or mute themselves.
+var errBlockSelf = errors.New("you cannot block or mute yourself")
+
+// BlockCache keeps the blocked users of connected users in memory. It is implemented by ws.Hub,
+// which drops the messages of blocked users while broadcasting.
+type BlockCache interface {
+	// SetBlockedUsers replaces the users blocked by userID.
+	SetBlockedUsers(userID string, blockedIDs []string)
+}
+
+// ListBlocks returns the users blocked or muted by the logged in user.
+func (s *service) ListBlocks(c context.Context, claims *MyJWTClaims) ([]BlockRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return s.Repository.ListBlocks(ctx, userID)
+}
+
+// SetBlock blocks or mutes another user, or changes a block into a mute and back.
+// Blocking also removes the contact or pending friend request between the two users.
+func (s *service) SetBlock(c context.Context, claims *MyJWTClaims, blockedID int64, req *SetBlockReq) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if userID == blockedID {
+		return InvalidRequest(errBlockSelf)
+	}
+	if _, err := s.Repository.GetUserByID(ctx, blockedID); err != nil {
+		return err
+	}
+
+	if err := s.Repository.SetBlock(ctx, userID, blockedID, req.Kind); err != nil {
+		return err
+	}
+	if req.Kind == BlockKindBlock {
+		if err := s.Repository.DeleteContacts(ctx, userID, blockedID); err != nil {
+			return err
+		}
+	}
+	return s.refreshBlockCache(ctx, userID)
+}
+
+// DeleteBlock unblocks or unmutes another user.
+func (s *service) DeleteBlock(c context.Context, claims *MyJWTClaims, blockedID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.DeleteBlock(ctx, userID, blockedID); err != nil {
+		return err
+	}
+	return s.refreshBlockCache(ctx, userID)
+}
+
+// BlockedUserIDs returns the IDs of the users blocked by the user, muted users left out.
+func (s *service) BlockedUserIDs(c context.Context, userID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return s.blockedUserIDs(ctx, id)
+}
+
+// refreshBlockCache pushes the blocked users of the user to the BlockCache after a change.
+func (s *service) refreshBlockCache(ctx context.Context, userID int64) error {
+	if s.blocks == nil {
+		return nil
+	}
+	ids, err := s.blockedUserIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.blocks.SetBlockedUsers(strconv.FormatInt(userID, 10), ids)
+	return nil
+}
+
+// blockedUserIDs reads the blocked users of the user as decimal strings, like the IDs used by the chat.
+func (s *service) blockedUserIDs(ctx context.Context, userID int64) ([]string, error) {
+	ids, err := s.Repository.ListBlockedIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, strconv.FormatInt(id, 10))
+	}
+	return res, nil
+}
@@ -0,0 +1,92 @@
+package users
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListBots method
+// It lists the bots owned by the logged in user.
+func (h *Handler) ListBots(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.ListBots(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// CreateBot method
+// It creates a bot owned by the logged in user and returns its access token, which cannot be read again later.
+func (h *Handler) CreateBot(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req CreateBotReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.CreateBot(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+// ResetBotToken method
+// It replaces the access token of the bot in the ":id" path parameter and returns the new one.
+func (h *Handler) ResetBotToken(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	botID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.Service.ResetBotToken(c.Request.Context(), claims, botID)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DeleteBot method
+// It deletes the bot in the ":id" path parameter.
+func (h *Handler) DeleteBot(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	botID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.DeleteBot(c.Request.Context(), claims, botID); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Bot Deleted Successfully"})
+}
@@ -0,0 +1,133 @@
+package users
+
+import (
+	"context"
+	"server/internal/audit"
+	"server/internal/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxBotsPerUser is how many bots a user may own.
+	maxBotsPerUser = 10
+
+	// botTokenTTL is the lifetime of the access token of a bot, ResetBotToken issues a new one.
+	botTokenTTL = 365 * 24 * time.Hour
+
+	// botTokenName names the access token of a bot in its token list.
+	botTokenName = "bot"
+)
+
+// botScopes are the scopes of the access token of a bot: it may read profiles and rooms, join rooms and post messages.
+var botScopes = []string{ScopeProfileRead, ScopeUsersRead, ScopeRoomsRead, ScopeMessagesWrite}
+
+// ListBots returns the bots owned by the logged in user.
+func (s *service) ListBots(c context.Context, claims *MyJWTClaims) ([]Profile, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	ownerID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	bots, err := s.Repository.ListBots(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Profile, 0, len(bots))
+	for _, bot := range bots {
+		res = append(res, bot.Profile())
+	}
+	return res, nil
+}
+
+// CreateBot creates a bot account owned by the logged in user and issues its access token.
+func (s *service) CreateBot(c context.Context, claims *MyJWTClaims, req *CreateBotReq) (*BotTokenRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	ownerID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	bot, err := s.Repository.CreateBot(ctx, &User{
+		Username:    req.Username,
+		DisplayName: strings.TrimSpace(req.DisplayName),
+		BotOwnerID:  &ownerID,
+	}, maxBotsPerUser)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueBotToken(ctx, bot)
+}
+
+// ResetBotToken deletes the access tokens of a bot owned by the logged in user, closes its connections
+// and issues a new token.
+func (s *service) ResetBotToken(c context.Context, claims *MyJWTClaims, botID int64) (*BotTokenRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	ownerID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	bot, err := s.Repository.GetBot(ctx, ownerID, botID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Repository.DeleteAccessTokens(ctx, bot.ID); err != nil {
+		return nil, err
+	}
+	if s.content != nil {
+		s.content.TerminateUser(strconv.FormatInt(bot.ID, 10))
+	}
+	return s.issueBotToken(ctx, bot)
+}
+
+// DeleteBot deletes a bot owned by the logged in user. Its rooms stay open without a creator.
+func (s *service) DeleteBot(c context.Context, claims *MyJWTClaims, botID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	ownerID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.DeleteBot(ctx, ownerID, botID); err != nil {
+		return err
+	}
+
+	if s.content != nil {
+		userID := strconv.FormatInt(botID, 10)
+		s.content.TerminateUser(userID)
+		s.content.AnonymizeUser(userID)
+	}
+	return nil
+}
+
+// issueBotToken creates an access token with botScopes for the bot.
+func (s *service) issueBotToken(ctx context.Context, bot User) (*BotTokenRes, error) {
+	secret, err := util.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	value := AccessTokenPrefix + secret
+
+	token, err := s.Repository.CreateAccessToken(ctx, &AccessToken{
+		UserID:    bot.ID,
+		Name:      botTokenName,
+		TokenHash: util.HashToken(value),
+		Scopes:    botScopes,
+		ExpiresAt: time.Now().Add(botTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if bot.BotOwnerID != nil {
+		s.auditTokenCreated(ctx, audit.TypeBotTokenCreated, *bot.BotOwnerID, token)
+	}
+	return &BotTokenRes{Bot: bot.Profile(), Token: value, ExpiresAt: token.ExpiresAt}, nil
+}
@@ -0,0 +1,215 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// Statuses of the rows of the contacts table.
+const (
+	ContactStatusPending  = "pending"
+	ContactStatusAccepted = "accepted"
+)
+
+// Events pushed to the connected clients of a user by the ContactNotifier.
+const (
+	// ContactEventRequest tells the recipient of a friend request who sent it.
+	ContactEventRequest = "contact_request"
+	// ContactEventAccepted tells the sender of a friend request that it was accepted.
+	ContactEventAccepted = "contact_accepted"
+	// ContactEventCancelled tells the recipient of a friend request that its sender withdrew it.
+	ContactEventCancelled = "contact_request_cancelled"
+)
+
+// errContactSelf is returned when users send a friend request to themselves.
+var errContactSelf = errors.New("you cannot send a friend request to yourself")
+
+// ContactNotifier pushes contact events to the open WebSockets of a user. It is implemented by ws.Hub.
+// Declined requests and removed contacts are not pushed, so users are not told who turned them down.
+type ContactNotifier interface {
+	// NotifyContact tells userID that from caused the event, one of the ContactEvent constants.
+	NotifyContact(userID string, event string, from Profile)
+}
+
+// ListContacts returns the contacts of the logged in user.
+func (s *service) ListContacts(c context.Context, claims *MyJWTClaims) ([]ContactRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return s.Repository.ListContacts(ctx, userID)
+}
+
+// ListContactRequests returns the pending friend requests received and sent by the logged in user.
+func (s *service) ListContactRequests(c context.Context, claims *MyJWTClaims) (*ContactRequestsRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	incoming, err := s.Repository.ListContactRequests(ctx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	outgoing, err := s.Repository.ListContactRequests(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	return &ContactRequestsRes{Incoming: incoming, Outgoing: outgoing}, nil
+}
+
+// SendContactRequest sends a friend request to another user. When that user already sent one to the
+// logged in user, it is accepted instead. Bots, users pending deletion and users who blocked the sender,
+// or were blocked by them, cannot get requests.
+func (s *service) SendContactRequest(c context.Context, claims *MyJWTClaims, req *SendContactRequestReq) (*SendContactRequestRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if userID == req.UserID {
+		return nil, InvalidRequest(errContactSelf)
+	}
+
+	sender, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	addressee, err := s.Repository.GetUserByID(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if addressee.DeleteAfter != nil {
+		return nil, ErrUserNotFound
+	}
+	if addressee.IsBot {
+		return nil, ErrContactRequestNotAllowed
+	}
+	blocked, err := s.blockedEitherWay(ctx, userID, addressee.ID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrContactRequestNotAllowed
+	}
+
+	existing, err := s.Repository.GetContact(ctx, userID, addressee.ID)
+	switch {
+	case errors.Is(err, ErrContactNotFound):
+		if err := s.Repository.CreateContactRequest(ctx, userID, addressee.ID); err != nil {
+			return nil, err
+		}
+		s.notifyContact(addressee.ID, ContactEventRequest, sender)
+		return &SendContactRequestRes{Status: ContactStatusPending}, nil
+	case err != nil:
+		return nil, err
+	case existing.Status == ContactStatusAccepted:
+		return nil, ErrAlreadyContacts
+	case existing.RequesterID == userID:
+		return nil, ErrContactRequestExists
+	}
+
+	// The other user asked first, sending a request back accepts theirs.
+	if err := s.Repository.AcceptContactRequest(ctx, addressee.ID, userID); err != nil {
+		return nil, err
+	}
+	s.notifyContact(addressee.ID, ContactEventAccepted, sender)
+	return &SendContactRequestRes{Status: ContactStatusAccepted}, nil
+}
+
+// AcceptContactRequest accepts the friend request sent by requesterID and tells them.
+func (s *service) AcceptContactRequest(c context.Context, claims *MyJWTClaims, requesterID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.AcceptContactRequest(ctx, requesterID, userID); err != nil {
+		return err
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.notifyContact(requesterID, ContactEventAccepted, user)
+	return nil
+}
+
+// DeclineContactRequest deletes the friend request sent by requesterID. They are not told, and may ask again.
+func (s *service) DeclineContactRequest(c context.Context, claims *MyJWTClaims, requesterID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return s.Repository.DeleteContactRequest(ctx, requesterID, userID)
+}
+
+// CancelContactRequest deletes the friend request sent to addresseeID and tells them.
+func (s *service) CancelContactRequest(c context.Context, claims *MyJWTClaims, addresseeID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.DeleteContactRequest(ctx, userID, addresseeID); err != nil {
+		return err
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.notifyContact(addresseeID, ContactEventCancelled, user)
+	return nil
+}
+
+// DeleteContact removes the contact between the logged in user and userID, for both of them.
+func (s *service) DeleteContact(c context.Context, claims *MyJWTClaims, otherID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return s.Repository.DeleteContact(ctx, userID, otherID)
+}
+
+// blockedEitherWay reports whether one of the two users blocked the other. Mutes do not count.
+func (s *service) blockedEitherWay(ctx context.Context, userID int64, otherID int64) (bool, error) {
+	for _, pair := range [][2]int64{{userID, otherID}, {otherID, userID}} {
+		ids, err := s.Repository.ListBlockedIDs(ctx, pair[0])
+		if err != nil {
+			return false, err
+		}
+		for _, id := range ids {
+			if id == pair[1] {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// notifyContact pushes a contact event to userID, if a ContactNotifier is set.
+func (s *service) notifyContact(userID int64, event string, from User) {
+	if s.contacts != nil {
+		s.contacts.NotifyContact(strconv.FormatInt(userID, 10), event, from.Profile())
+	}
+}
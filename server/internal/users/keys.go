@@ -0,0 +1,220 @@
+package users
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"server/config"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is one JWT key. signKey is nil for retired keys that only verify tokens.
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet holds every key that may verify a session token and the one that signs new tokens.
+type KeySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet loads the keys listed in the configuration.
+// Without any configured key it generates an ephemeral Ed25519 key, so tokens do not survive a restart.
+func NewKeySet(cfg config.JWTConfig) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*signingKey)}
+
+	if len(cfg.Keys) == 0 {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("jwt: no signing keys configured, using an ephemeral Ed25519 key")
+
+		ks.active = &signingKey{id: "ephemeral", method: jwt.SigningMethodEdDSA, signKey: priv, verifyKey: priv.Public()}
+		ks.keys[ks.active.id] = ks.active
+		return ks, nil
+	}
+
+	for _, kc := range cfg.Keys {
+		key, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kc.ID, err)
+		}
+		if _, ok := ks.keys[key.id]; ok {
+			return nil, fmt.Errorf("jwt key %q: duplicate kid", kc.ID)
+		}
+		ks.keys[key.id] = key
+	}
+
+	active, ok := ks.keys[cfg.ActiveKey]
+	if !ok {
+		return nil, fmt.Errorf("jwt active_key %q is not listed in keys", cfg.ActiveKey)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("jwt active_key %q has no private key", cfg.ActiveKey)
+	}
+	ks.active = active
+
+	return ks, nil
+}
+
+// loadKey reads the key material of one configured key.
+func loadKey(kc config.KeyConfig) (*signingKey, error) {
+	if kc.ID == "" {
+		return nil, fmt.Errorf("missing kid")
+	}
+	key := &signingKey{id: kc.ID}
+
+	switch kc.Algorithm {
+	case "HS256":
+		secret := os.Getenv(kc.SecretEnv)
+		if kc.SecretEnv == "" || secret == "" {
+			return nil, fmt.Errorf("HS256 keys need a non-empty secret_env")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(secret)
+		key.verifyKey = []byte(secret)
+
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = priv
+			key.verifyKey = &priv.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		}
+
+	case "EdDSA":
+		key.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			edPriv, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("not an Ed25519 private key")
+			}
+			key.signKey = edPriv
+			key.verifyKey = edPriv.Public()
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseEdPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Algorithm)
+	}
+
+	if key.verifyKey == nil {
+		return nil, fmt.Errorf("%s keys need a private_key_file or public_key_file", kc.Algorithm)
+	}
+	return key, nil
+}
+
+// Sign signs the claims with the active key and sets the "kid" header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.id
+
+	return token.SignedString(ks.active.signKey)
+}
+
+// Keyfunc selects the verification key by the "kid" header for jwt.Parse.
+// The token's algorithm must match the algorithm configured for that key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for kid %q", token.Header["alg"], kid)
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys of the set. HS256 keys are shared secrets and are never published.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+
+	for _, key := range ks.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.id,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.id,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
@@ -0,0 +1,120 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"server/internal/audit"
+	"server/internal/mailer"
+	"server/internal/throttle"
+	"server/internal/util"
+)
+
+// loginMethodMagicLink is the login method of the sessions started from an emailed link.
+const loginMethodMagicLink = "magic_link"
+
+// RequestMagicLink mails a single-use login link to the account with the given email.
+// It returns nil for unknown addresses so callers cannot probe for accounts. Every request counts against the
+// throttle.MagicLinkKey of the address, which bounds how many links it receives, and is refused while the
+// account or the client IP is throttled for failed logins. Requests are not failed logins themselves.
+func (s *service) RequestMagicLink(c context.Context, email string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	magicKey := throttle.MagicLinkKey(email)
+	if err := s.guard.Check(ctx, throttle.AccountKey(email), throttle.IPKey(util.ClientInfoFrom(c).IP), magicKey); err != nil {
+		s.auditLoginFailed(ctx, email, nil, loginMethodMagicLink, err)
+		return err
+	}
+	if err := s.guard.Failure(ctx, magicKey); err != nil {
+		log.Printf("magic link: count request: %v", err)
+	}
+
+	user, err := s.Repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		s.recordAudit(ctx, audit.Event{Type: audit.TypeMagicLinkRequested, Email: email, Reason: "user_not_found"})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent link works.
+	if err := s.Repository.DeleteUserTokens(ctx, user.ID, TokenPurposeMagicLink); err != nil {
+		return err
+	}
+	token, err := s.createUserToken(ctx, user.ID, TokenPurposeMagicLink, s.magicLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to log in to your account without a password. Log in here:\n\n%s\n\nThe link works once and expires in %s. If you did not ask for it, you can ignore this email.\n",
+			user.Username, s.link("/login/magic-link/redeem", token), s.magicLinkTTL),
+	})
+	s.auditUserEvent(ctx, audit.TypeMagicLinkRequested, user, nil)
+	return nil
+}
+
+// LoginMagicLink redeems a login link and starts the session, like LoginUser after a correct password.
+// The link proves that the user owns the email address, which is marked as verified.
+// Accounts with two-factor authentication get a challenge instead of a session.
+// Unknown, expired or already used links count as failed logins of the client IP.
+func (s *service) LoginMagicLink(c context.Context, token string) (LoginUserRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	ipKey := throttle.IPKey(util.ClientInfoFrom(c).IP)
+	if err := s.guard.Check(ctx, ipKey); err != nil {
+		s.auditLoginFailed(ctx, "", nil, loginMethodMagicLink, err)
+		return LoginUserRes{}, err
+	}
+
+	// The token is only consumed once the account is known not to be locked out.
+	tokenHash := util.HashToken(token)
+	ut, err := s.Repository.GetUserToken(ctx, TokenPurposeMagicLink, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.auditLoginFailed(ctx, "", nil, loginMethodMagicLink, ErrInvalidToken)
+		return LoginUserRes{}, s.loginFailed(ctx, []string{ipKey}, ErrInvalidToken)
+	}
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+	user, err := s.Repository.GetUserByID(ctx, ut.UserID)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+	if err := s.guard.Check(ctx, throttle.AccountKey(user.Email)); err != nil {
+		s.auditLoginFailed(ctx, user.Email, &user, loginMethodMagicLink, err)
+		return LoginUserRes{}, err
+	}
+
+	// Another request may have redeemed the link since it was read.
+	_, err = s.Repository.ConsumeUserToken(ctx, TokenPurposeMagicLink, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.auditLoginFailed(ctx, user.Email, &user, loginMethodMagicLink, ErrInvalidToken)
+		return LoginUserRes{}, s.loginFailed(ctx, []string{ipKey}, ErrInvalidToken)
+	}
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	if !user.EmailVerified {
+		if err := s.Repository.SetEmailVerified(ctx, user.ID); err != nil {
+			return LoginUserRes{}, err
+		}
+		user.EmailVerified = true
+	}
+
+	if err := s.refuseDeletedAccount(ctx, user, loginMethodMagicLink); err != nil {
+		return LoginUserRes{}, err
+	}
+	if user.TOTPEnabled {
+		return s.startTwoFactorChallenge(ctx, user)
+	}
+	return s.loginSucceeded(ctx, user, loginMethodMagicLink)
+}
@@ -0,0 +1,43 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/throttle"
+	"testing"
+)
+
+// TestMailRequestsAreThrottled checks that the emails anyone can request for an address are limited per address.
+func TestMailRequestsAreThrottled(t *testing.T) {
+	svc, err := NewService(newMemoryRepository(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		request func(email string) error
+	}{
+		{name: "password reset", request: func(email string) error { return svc.ForgotPassword(ctx, email) }},
+		{name: "verification", request: func(email string) error { return svc.ResendVerification(ctx, email) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Unknown addresses count too, otherwise the limit would reveal which ones have an account.
+			// The default mail rule lets 3 requests go, the 4th makes the next one wait.
+			for i := 0; i < 4; i++ {
+				if err := tt.request("nobody@example.com"); err != nil {
+					t.Fatalf("request %d: err = %v", i+1, err)
+				}
+			}
+			if err := tt.request("Nobody@example.com"); !errors.Is(err, throttle.ErrLimited) {
+				t.Fatalf("err = %v, want ErrLimited", err)
+			}
+			if err := tt.request("somebody@example.com"); err != nil {
+				t.Fatalf("other address: err = %v", err)
+			}
+		})
+	}
+}
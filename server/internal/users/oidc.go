@@ -0,0 +1,539 @@
+package users
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"server/config"
+	"server/internal/util"
+	"server/internal/validation"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// oidcDiscoveryTTL is how long the provider metadata is cached.
+	oidcDiscoveryTTL = time.Hour
+	// oidcKeysMinRefresh limits how often an unknown kid triggers a JWKS download.
+	oidcKeysMinRefresh = time.Minute
+	// OIDCStateTTL is how long a user has to finish the login at the identity provider.
+	OIDCStateTTL = 10 * time.Minute
+)
+
+// oidcDiscovery is the part of the OpenID Provider metadata the login flow needs.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcIDTokenClaims are the ID token claims used to link or provision an account.
+type oidcIDTokenClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// oidcProvider talks to the configured OpenID Connect identity provider.
+// It caches the discovery document and the provider's signing keys.
+type oidcProvider struct {
+	cfg          config.OIDCConfig
+	clientSecret string
+	client       *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// newOIDCProvider creates a provider client, or returns nil when OIDC login is disabled.
+func newOIDCProvider(cfg config.OIDCConfig) *oidcProvider {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &oidcProvider{
+		cfg:          cfg,
+		clientSecret: os.Getenv(cfg.ClientSecretEnv),
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// getJSON fetches url and decodes the JSON body into v.
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// discover returns the cached provider metadata, fetching it when missing or stale.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	d := &oidcDiscovery{}
+	wellKnown := strings.TrimRight(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match configured %q", d.Issuer, p.cfg.Issuer)
+	}
+
+	p.discovery = d
+	p.discoveredAt = time.Now()
+	return d, nil
+}
+
+// AuthCodeURL builds the authorization request URL with PKCE (S256).
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the raw ID token.
+func (p *oidcProvider) Exchange(ctx context.Context, code string, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("oidc token request: %s %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("oidc token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token.
+func (p *oidcProvider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*oidcIDTokenClaims, error) {
+	claims := &oidcIDTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("id token issuer %q does not match %q", claims.Issuer, p.cfg.Issuer)
+	}
+	if !claims.VerifyAudience(p.cfg.ClientID, true) {
+		return nil, fmt.Errorf("id token audience does not contain %q", p.cfg.ClientID)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, fmt.Errorf("id token azp %q does not match %q", claims.AuthorizedParty, p.cfg.ClientID)
+	}
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("id token has no exp")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token has no sub")
+	}
+	return claims, nil
+}
+
+// key returns the provider's public key with the given kid, downloading the JWKS again
+// when the kid is unknown, which happens after the provider rotated its keys.
+func (p *oidcProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetchedAt) > oidcKeysMinRefresh
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown oidc signing key %q", kid)
+	}
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if use := jwk["use"]; use != "" && use != "sig" {
+			continue
+		}
+		pub, err := parseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk["kid"]] = pub
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		// Providers with a single key sometimes omit the kid.
+		if kid == "" && len(keys) == 1 {
+			for _, k := range keys {
+				return k, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown oidc signing key %q", kid)
+	}
+	return key, nil
+}
+
+// parseJWK converts an RSA, P-256/P-384 EC or Ed25519 JWK into a public key.
+func parseJWK(jwk map[string]string) (interface{}, error) {
+	decode := func(field string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk[field], "="))
+	}
+
+	switch jwk["kty"] {
+	case "RSA":
+		n, err := decode("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e")
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk["crv"])
+		}
+		x, err := decode("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y")
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk["crv"])
+		}
+		x, err := decode("x")
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported kty %q", jwk["kty"])
+}
+
+// OIDCLogin starts a login at the identity provider.
+// The returned state must be stored in a short-lived cookie and handed back to OIDCCallback.
+func (s *service) OIDCLogin(c context.Context) (*OIDCLoginRes, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	state, err := util.GenerateToken(16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := util.GenerateToken(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := util.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := s.oidc.AuthCodeURL(c, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCLoginRes{
+		URL:   authURL,
+		State: strings.Join([]string{state, nonce, verifier}, "."),
+	}, nil
+}
+
+// OIDCCallback finishes a login at the identity provider: it checks the state, redeems the code,
+// verifies the ID token and links or provisions the matching account before starting a session.
+// Multi-factor authentication is left to the identity provider.
+func (s *service) OIDCCallback(c context.Context, req *OIDCCallbackReq) (LoginUserRes, error) {
+	if s.oidc == nil {
+		return LoginUserRes{}, ErrOIDCDisabled
+	}
+
+	parts := strings.Split(req.StoredState, ".")
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(req.State)) != 1 {
+		return LoginUserRes{}, ErrOIDCInvalidState
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	// The identity provider round trips get their own deadline, the database work uses s.timeout.
+	idpCtx, cancelIdp := context.WithTimeout(c, 15*time.Second)
+	defer cancelIdp()
+
+	rawIDToken, err := s.oidc.Exchange(idpCtx, req.Code, verifier)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+	claims, err := s.oidc.VerifyIDToken(idpCtx, rawIDToken, nonce)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userForIdentity(ctx, claims)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	res, err := s.startSession(ctx, user, loginMethodOIDC)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+	res.redirect_to = s.oidc.cfg.PostLoginRedirect
+	return res, nil
+}
+
+// userForIdentity finds the account linked to the ID token's subject.
+// Unlinked subjects are linked to the account with the same email when both the identity provider and the
+// account verified it, or provisioned when allowed.
+func (s *service) userForIdentity(ctx context.Context, claims *oidcIDTokenClaims) (User, error) {
+	issuer := s.oidc.cfg.Issuer
+
+	user, err := s.Repository.GetUserByIdentity(ctx, issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return User{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return User{}, ErrOIDCAccountNotLinked
+	}
+
+	user, err = s.Repository.GetUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil && !user.EmailVerified:
+		// Someone registered the address without proving they own it, possibly to take over the account
+		// once its real owner signs in. The owner has to verify the address or reset the password first.
+		return User{}, ErrOIDCAccountNotLinked
+	case err == nil:
+		// Both the identity provider and the account vouch for the address, so it is safe to link.
+	case errors.Is(err, ErrUserNotFound) && s.oidc.cfg.AutoProvision:
+		user, err = s.provisionOIDCUser(ctx, claims)
+		if err != nil {
+			return User{}, err
+		}
+	case errors.Is(err, ErrUserNotFound):
+		return User{}, ErrOIDCAccountNotLinked
+	default:
+		return User{}, err
+	}
+
+	err = s.Repository.CreateIdentity(ctx, &Identity{
+		UserID:  user.ID,
+		Issuer:  issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	// Provisioned accounts are created unverified, the identity provider vouched for their address.
+	if !user.EmailVerified {
+		if err := s.Repository.SetEmailVerified(ctx, user.ID); err != nil {
+			return User{}, err
+		}
+		user.EmailVerified = true
+	}
+	return user, nil
+}
+
+// usernameCharset matches the characters that are dropped from a provisioned username.
+var usernameCharset = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// usernameSuffixLen is the room provisioned names leave for a 4 digit suffix.
+const usernameSuffixLen = 4
+
+// provisionedUsername turns a name from the identity provider into one that passes the "username" validation
+// rule, even once a numeric suffix is added: unsupported characters are dropped, long names are truncated
+// and short ones prefixed with "user".
+func provisionedUsername(name string) string {
+	name = usernameCharset.ReplaceAllString(name, "")
+	if len(name) < validation.MinUsernameLength {
+		name = "user" + name
+	}
+	if limit := validation.MaxUsernameLength - usernameSuffixLen; len(name) > limit {
+		name = name[:limit]
+	}
+	return name
+}
+
+// provisionOIDCUser creates an account for a new identity. It gets a random password,
+// which the user can replace through the password reset flow if they ever need one.
+func (s *service) provisionOIDCUser(ctx context.Context, claims *oidcIDTokenClaims) (User, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+	username = provisionedUsername(username)
+
+	password, err := util.GenerateToken(32)
+	if err != nil {
+		return User{}, err
+	}
+	hashpw, err := s.passwordHasher.Hash(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	// A taken username gets a random numeric suffix, the user can still pick another one later.
+	candidate := username
+	for attempt := 0; ; attempt++ {
+		u, err := s.Repository.CreateUser(ctx, &User{
+			Username: candidate,
+			Password: hashpw,
+			Email:    claims.Email,
+		})
+		if err == nil {
+			return *u, nil
+		}
+		if !errors.Is(err, ErrUsernameTaken) || attempt == 4 {
+			return User{}, err
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return User{}, err
+		}
+		candidate = fmt.Sprintf("%s%04d", username, n.Int64())
+	}
+}
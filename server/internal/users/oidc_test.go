@@ -0,0 +1,217 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/config"
+	"server/internal/oidctest"
+	"server/internal/validation"
+	"strings"
+	"testing"
+)
+
+const (
+	testOIDCClientID    = "go-chat"
+	testOIDCRedirectURL = "http://chat.test/oidc/callback"
+)
+
+// oidcLogin runs the authorization code flow against the identity provider and returns the result of
+// the callback. tamper may change the stored state parts (state, nonce, verifier) before the callback.
+func oidcLogin(t *testing.T, s *service, tamper func(parts []string)) (LoginUserRes, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	login, err := s.OIDCLogin(ctx)
+	if err != nil {
+		t.Fatalf("OIDCLogin: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(login.URL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize redirect: %v", err)
+	}
+
+	parts := strings.Split(login.State, ".")
+	if tamper != nil {
+		tamper(parts)
+	}
+	return s.OIDCCallback(ctx, &OIDCCallbackReq{
+		Code:        back.Query().Get("code"),
+		State:       back.Query().Get("state"),
+		StoredState: strings.Join(parts, "."),
+	})
+}
+
+func TestOIDCCallback(t *testing.T) {
+	identity := oidctest.Identity{
+		Subject:       "idp-user-1",
+		Email:         "sso.user@example.com",
+		EmailVerified: true,
+		Username:      "sso.user",
+	}
+	existing := User{ID: 7, Username: "existing", Email: "SSO.User@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name          string
+		users         []User
+		autoProvision bool
+		emailVerified bool
+		tamper        func(parts []string)
+		wantErr       error
+		wantAnyErr    bool
+		wantUserID    string
+	}{
+		{
+			name:          "links an existing user",
+			users:         []User{existing},
+			emailVerified: true,
+			wantUserID:    "7",
+		},
+		{
+			name:          "provisions a new user",
+			autoProvision: true,
+			emailVerified: true,
+			wantUserID:    "1",
+		},
+		{
+			name:          "refuses an unknown user without auto provisioning",
+			emailVerified: true,
+			wantErr:       ErrOIDCAccountNotLinked,
+		},
+		{
+			name:          "refuses an email the provider did not verify",
+			users:         []User{existing},
+			autoProvision: true,
+			emailVerified: false,
+			wantErr:       ErrOIDCAccountNotLinked,
+		},
+		{
+			name:          "refuses to link an unverified account",
+			users:         []User{{ID: 7, Username: "squatter", Email: "sso.user@example.com"}},
+			emailVerified: true,
+			wantErr:       ErrOIDCAccountNotLinked,
+		},
+		{
+			name:          "rejects a state mismatch",
+			users:         []User{existing},
+			emailVerified: true,
+			tamper:        func(parts []string) { parts[0] = "forged" },
+			wantErr:       ErrOIDCInvalidState,
+		},
+		{
+			name:          "rejects a nonce mismatch",
+			users:         []User{existing},
+			emailVerified: true,
+			tamper:        func(parts []string) { parts[1] = "forged" },
+			wantAnyErr:    true,
+		},
+		{
+			name:          "rejects a PKCE verifier mismatch",
+			users:         []User{existing},
+			emailVerified: true,
+			tamper:        func(parts []string) { parts[2] = "forged" },
+			wantAnyErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := identity
+			id.EmailVerified = tt.emailVerified
+			idp, err := oidctest.New("http://placeholder", testOIDCClientID, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(idp.Handler())
+			defer srv.Close()
+			idp.Issuer = srv.URL
+
+			cfg := config.Default()
+			cfg.OIDC = config.OIDCConfig{
+				Enabled:       true,
+				Issuer:        srv.URL,
+				ClientID:      testOIDCClientID,
+				RedirectURL:   testOIDCRedirectURL,
+				Scopes:        []string{"openid", "email"},
+				AutoProvision: tt.autoProvision,
+			}
+			repo := newMemoryRepository(tt.users...)
+			svc, err := NewService(repo, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := oidcLogin(t, svc.(*service), tt.tamper)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantAnyErr:
+				if err == nil {
+					t.Fatal("err = nil, want an error")
+				}
+			case err != nil:
+				t.Fatalf("err = %v", err)
+			}
+			if tt.wantUserID == "" {
+				if len(repo.identities) != 0 || len(repo.sessions) != 0 {
+					t.Fatalf("refused login linked %d identities and started %d sessions", len(repo.identities), len(repo.sessions))
+				}
+				return
+			}
+
+			if res.ID != tt.wantUserID {
+				t.Fatalf("logged in user %s, want %s", res.ID, tt.wantUserID)
+			}
+			linked, err := repo.GetUserByIdentity(context.Background(), srv.URL, identity.Subject)
+			if err != nil {
+				t.Fatalf("identity not linked: %v", err)
+			}
+			if linked.ID != existing.ID && linked.Username != identity.Username {
+				t.Fatalf("provisioned username %q, want %q", linked.Username, identity.Username)
+			}
+			if !linked.EmailVerified {
+				t.Fatal("linked account is not verified")
+			}
+		})
+	}
+}
+
+func TestProvisionedUsername(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "sso.user", want: "sso.user"},
+		{name: "Jane Doe!", want: "JaneDoe"},
+		{name: "jo", want: "userjo"},
+		{name: "名前", want: "user"},
+		{name: strings.Repeat("a", 40), want: strings.Repeat("a", validation.MaxUsernameLength-usernameSuffixLen)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := provisionedUsername(tt.name)
+			if got != tt.want {
+				t.Fatalf("provisionedUsername(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+			if !validation.Username(got) || !validation.Username(got+"1234") {
+				t.Fatalf("%q does not pass the username rule with or without a suffix", got)
+			}
+		})
+	}
+}
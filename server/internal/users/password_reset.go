@@ -0,0 +1,101 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"server/internal/audit"
+	"server/internal/mailer"
+	"server/internal/throttle"
+	"server/internal/util"
+)
+
+// ForgotPassword mails a single-use password reset link to the account with the given email.
+// It returns nil for unknown addresses so callers cannot probe for accounts. Every request counts against the
+// throttle.MailKey of the address and the throttle.MailIPKey of the client, which bound how many emails are sent.
+func (s *service) ForgotPassword(c context.Context, email string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	keys := []string{throttle.MailKey(TokenPurposePasswordReset, email), throttle.MailIPKey(util.ClientInfoFrom(c).IP)}
+	if err := s.guard.Check(ctx, keys...); err != nil {
+		return err
+	}
+	if err := s.guard.Failure(ctx, keys...); err != nil {
+		log.Printf("forgot password: count request: %v", err)
+	}
+
+	user, err := s.Repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent link works.
+	if err := s.Repository.DeleteUserTokens(ctx, user.ID, TokenPurposePasswordReset); err != nil {
+		return err
+	}
+	token, err := s.createUserToken(ctx, user.ID, TokenPurposePasswordReset, s.passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. Choose a new password here:\n\n%s\n\nThe link expires in %s. If you did not ask for it, you can ignore this email.\n",
+			user.Username, s.link("/password/reset", token), s.passwordResetTTL),
+	})
+	return nil
+}
+
+// ResetPassword consumes a password reset token, stores the new password and logs out every session of the user.
+func (s *service) ResetPassword(c context.Context, req *ResetPasswordReq) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	// The policy is checked before the token is consumed, so a refused password does not burn the link.
+	tokenHash := util.HashToken(req.Token)
+	ut, err := s.Repository.GetUserToken(ctx, TokenPurposePasswordReset, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	user, err := s.Repository.GetUserByID(ctx, ut.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.passwordPolicy.Check(req.Password, user.Username, user.Email); err != nil {
+		return err
+	}
+
+	ut, err = s.Repository.ConsumeUserToken(ctx, TokenPurposePasswordReset, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	hashpw, err := s.passwordHasher.Hash(req.Password)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.UpdatePassword(ctx, ut.UserID, hashpw); err != nil {
+		return err
+	}
+
+	// Following the emailed link proves ownership of the address.
+	if err := s.Repository.SetEmailVerified(ctx, ut.UserID); err != nil {
+		return err
+	}
+	s.auditUserEvent(ctx, audit.TypePasswordReset, user, nil)
+
+	return s.revokeAllSessions(ctx, ut.UserID, RevokeReasonPasswordReset)
+}
@@ -0,0 +1,51 @@
+package users
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Presence statuses of the users.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// PresenceSource tells whether users are connected to the chat right now.
+// It is implemented by ws.Hub, which aggregates the connections of a user over every room.
+type PresenceSource interface {
+	// UserPresence returns PresenceOnline, PresenceAway or PresenceOffline.
+	UserPresence(userID string) string
+}
+
+// GetPresence returns the live status of a user from the PresenceSource and their last seen time.
+// Without a PresenceSource every user is reported offline.
+func (s *service) GetPresence(c context.Context, userID int64) (*PresenceRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Accounts waiting for deletion are hidden as if they were already gone.
+	if user.DeleteAfter != nil {
+		return nil, ErrUserNotFound
+	}
+
+	status := PresenceOffline
+	if s.presence != nil {
+		status = s.presence.UserPresence(strconv.FormatInt(user.ID, 10))
+	}
+	return &PresenceRes{UserID: user.ID, Status: status, LastSeenAt: user.LastSeenAt}, nil
+}
+
+// RecordLastSeen stores the last time a user was connected, called by the chat when they come online or go offline.
+func (s *service) RecordLastSeen(c context.Context, userID int64, at time.Time) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.Repository.SetLastSeen(ctx, userID, at.UTC())
+}
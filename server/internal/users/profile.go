@@ -0,0 +1,200 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"server/internal/mailer"
+	"server/internal/throttle"
+	"server/internal/util"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits of the profile fields.
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxAvatarURLLength   = 2048
+)
+
+// GetMe returns the profile and account settings of the logged in user.
+func (s *service) GetMe(c context.Context, claims *MyJWTClaims) (*MeRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMeRes(user), nil
+}
+
+// GetProfile returns the public profile of the user with the given ID.
+func (s *service) GetProfile(c context.Context, userID int64) (*Profile, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Accounts waiting for deletion are hidden as if they were already gone.
+	if user.DeleteAfter != nil {
+		return nil, ErrUserNotFound
+	}
+
+	profile := user.Profile()
+	return &profile, nil
+}
+
+// UpdateProfile validates and stores the fields present in the request, the others keep their value.
+func (s *service) UpdateProfile(c context.Context, claims *MyJWTClaims, req *UpdateProfileReq) (*MeRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(user.DisplayName) > maxDisplayNameLength {
+			return nil, fmt.Errorf("%w: display_name is longer than %d characters", ErrInvalidProfile, maxDisplayNameLength)
+		}
+	}
+	if req.Bio != nil {
+		user.Bio = strings.TrimSpace(*req.Bio)
+		if utf8.RuneCountInString(user.Bio) > maxBioLength {
+			return nil, fmt.Errorf("%w: bio is longer than %d characters", ErrInvalidProfile, maxBioLength)
+		}
+	}
+	if req.AvatarURL != nil {
+		user.AvatarURL = strings.TrimSpace(*req.AvatarURL)
+		if err := validateAvatarURL(user.AvatarURL); err != nil {
+			return nil, err
+		}
+	}
+	if req.Timezone != nil {
+		user.Timezone = strings.TrimSpace(*req.Timezone)
+		if user.Timezone == "" {
+			user.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(user.Timezone); err != nil || strings.EqualFold(user.Timezone, "Local") {
+			return nil, fmt.Errorf("%w: timezone %q is not an IANA time zone", ErrInvalidProfile, user.Timezone)
+		}
+	}
+
+	updated, err := s.Repository.UpdateProfile(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+	return newMeRes(updated), nil
+}
+
+// ChangeEmail re-authenticates the user, stores the new address as unverified and mails a verification link to it.
+// The previous address is told about the change, so a hijacked session cannot take the account over silently.
+func (s *service) ChangeEmail(c context.Context, claims *MyJWTClaims, req *ChangeEmailReq) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	addr, err := mail.ParseAddress(req.NewEmail)
+	if err != nil || addr.Address != strings.TrimSpace(req.NewEmail) {
+		return fmt.Errorf("%w: new_email is not a valid email address", ErrInvalidProfile)
+	}
+	email := addr.Address
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return err
+	}
+	// The address is only checked after re-authentication, so a stolen session cannot probe for accounts.
+	if err := s.reauthenticate(ctx, user, req.CurrentPassword, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+	if strings.EqualFold(email, user.Email) {
+		return nil
+	}
+
+	// The users_email_key index rejects an address of another account with ErrEmailTaken.
+	if err := s.Repository.UpdateEmail(ctx, user.ID, email); err != nil {
+		return err
+	}
+	// Links sent to the old address must not verify the new one.
+	if err := s.Repository.DeleteUserTokens(ctx, user.ID, TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s. If you did not do this, reset your password and contact us.\n",
+			user.Username, email),
+	})
+
+	oldEmail := user.Email
+	user.Email = email
+	if err := s.sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("change email: send verification email to %s (was %s): %v", email, oldEmail, err)
+	}
+	return nil
+}
+
+// reauthenticate checks the password of the user, and a second factor when two-factor authentication is enabled,
+// before a sensitive change to the account. Wrong answers count as failed logins of the account and the client IP,
+// so the throttle of the login also bounds guessing through these routes.
+func (s *service) reauthenticate(ctx context.Context, user User, password string, code string, recoveryCode string) error {
+	keys := []string{throttle.AccountKey(user.Email), throttle.IPKey(util.ClientInfoFrom(ctx).IP)}
+	if err := s.guard.Check(ctx, keys...); err != nil {
+		s.auditLoginFailed(ctx, user.Email, &user, loginMethodReauthentication, err)
+		return err
+	}
+
+	err := ErrInvalidPassword
+	if util.CheckPassword(user.Password, password) == nil {
+		err = nil
+		if user.TOTPEnabled {
+			err = s.checkSecondFactor(ctx, user, code, recoveryCode)
+		}
+	}
+	if errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrInvalidTOTPCode) {
+		s.auditLoginFailed(ctx, user.Email, &user, loginMethodReauthentication, err)
+		return s.loginFailed(ctx, keys, err)
+	}
+	return err
+}
+
+// validateAvatarURL accepts an empty value, which removes the avatar, or an absolute http(s) URL.
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	if len(avatarURL) > maxAvatarURLLength {
+		return fmt.Errorf("%w: avatar_url is longer than %d characters", ErrInvalidProfile, maxAvatarURLLength)
+	}
+
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: avatar_url must be an http or https URL", ErrInvalidProfile)
+	}
+	return nil
+}
+
+// newMeRes builds the account view of a user.
+func newMeRes(user User) *MeRes {
+	return &MeRes{
+		Profile:       user.Profile(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		TOTPEnabled:   user.TOTPEnabled,
+		Role:          user.Role,
+		DeleteAfter:   user.DeleteAfter,
+		UpdatedAt:     user.UpdatedAt,
+	}
+}
@@ -0,0 +1,33 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/throttle"
+	"testing"
+)
+
+func TestReauthenticateIsThrottled(t *testing.T) {
+	svc, err := NewService(newMemoryRepository(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := svc.(*service)
+	hash, err := s.passwordHasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{ID: 1, Email: "jane@example.com", Password: hash}
+	ctx := context.Background()
+
+	// The default account rule lets 3 failures go, the 4th makes the next attempt wait.
+	for i := 0; i < 4; i++ {
+		if err := s.reauthenticate(ctx, user, "wrong", "", ""); !errors.Is(err, ErrInvalidPassword) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidPassword", i+1, err)
+		}
+	}
+	if err := s.reauthenticate(ctx, user, "correct horse battery staple", "", ""); !errors.Is(err, throttle.ErrLimited) {
+		t.Fatalf("err = %v, want ErrLimited", err)
+	}
+}
@@ -0,0 +1,148 @@
+package users
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// memoryRepository keeps users and their identities in memory for the tests of the service.
+// It embeds the Repository interface, so a test reaching a method it does not implement panics.
+type memoryRepository struct {
+	Repository
+
+	mu         sync.Mutex
+	users      map[int64]User
+	identities []Identity
+	sessions   []Session
+	// recoveryCodes holds the unused recovery code hashes of each user.
+	recoveryCodes map[int64]map[string]bool
+}
+
+func newMemoryRepository(users ...User) *memoryRepository {
+	r := &memoryRepository{users: make(map[int64]User), recoveryCodes: make(map[int64]map[string]bool)}
+	for _, u := range users {
+		r.users[u.ID] = u
+	}
+	return r
+}
+
+func (r *memoryRepository) CreateUser(ctx context.Context, newUser *User) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, newUser.Email) {
+			return nil, ErrEmailTaken
+		}
+		if strings.EqualFold(u.Username, newUser.Username) {
+			return nil, ErrUsernameTaken
+		}
+	}
+	u := *newUser
+	u.ID = int64(len(r.users) + 1)
+	r.users[u.ID] = u
+	return &u, nil
+}
+
+func (r *memoryRepository) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (r *memoryRepository) GetUserByID(ctx context.Context, id int64) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (r *memoryRepository) SetEmailVerified(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.EmailVerified = true
+	r.users[userID] = u
+	return nil
+}
+
+func (r *memoryRepository) GetUserByIdentity(ctx context.Context, issuer string, subject string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range r.identities {
+		if id.Issuer == issuer && id.Subject == subject {
+			return r.users[id.UserID], nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (r *memoryRepository) CreateIdentity(ctx context.Context, identity *Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *memoryRepository) CreateSession(ctx context.Context, session *Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions = append(r.sessions, *session)
+	return nil
+}
+
+func (r *memoryRepository) CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error) {
+	return token, nil
+}
+
+func (r *memoryRepository) ReplaceTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := r.users[userID]
+	u.TOTPSecret = secret
+	r.users[userID] = u
+	return nil
+}
+
+func (r *memoryRepository) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := r.users[userID]
+	if step <= u.TOTPLastStep {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	r.users[userID] = u
+	return true, nil
+}
+
+func (r *memoryRepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.recoveryCodes[userID][codeHash] {
+		return false, nil
+	}
+	delete(r.recoveryCodes[userID], codeHash)
+	return true, nil
+}
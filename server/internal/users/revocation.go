@@ -0,0 +1,148 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// revocationCheckTTL is how long a "not revoked" answer from the database is trusted.
+// It bounds how long a session revoked on another server node stays usable here.
+const revocationCheckTTL = 30 * time.Second
+
+// SessionTerminator closes live connections that belong to revoked sessions.
+// It is implemented by ws.Hub so revoking a session also drops its WebSockets.
+type SessionTerminator interface {
+	TerminateSessions(sessionIDs ...string)
+}
+
+// revocationCache is the in-memory front of the revoked_sessions table.
+// Revoked sessions are remembered until every access token they issued has expired,
+// sessions that are known to be valid are re-checked after revocationCheckTTL.
+type revocationCache struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time // session ID -> time after which the entry can be dropped
+	checked map[string]time.Time // session ID -> time after which the database must be asked again
+}
+
+// newRevocationCache creates an empty revocationCache.
+func newRevocationCache() *revocationCache {
+	return &revocationCache{
+		revoked: make(map[string]time.Time),
+		checked: make(map[string]time.Time),
+	}
+}
+
+// lookup reports whether the session is revoked, and whether the answer came from the cache.
+func (rc *revocationCache) lookup(sessionID string, now time.Time) (revoked bool, cached bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if _, ok := rc.revoked[sessionID]; ok {
+		return true, true
+	}
+	if until, ok := rc.checked[sessionID]; ok && now.Before(until) {
+		return false, true
+	}
+	return false, false
+}
+
+// markRevoked remembers a revoked session until expiresAt.
+func (rc *revocationCache) markRevoked(sessionID string, expiresAt time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.revoked[sessionID] = expiresAt
+	delete(rc.checked, sessionID)
+	rc.prune(time.Now())
+}
+
+// markValid remembers that the database reported the session as not revoked.
+func (rc *revocationCache) markValid(sessionID string, now time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.checked[sessionID] = now.Add(revocationCheckTTL)
+	if len(rc.checked) > 10000 {
+		rc.prune(now)
+	}
+}
+
+// prune drops entries that no longer matter. The caller must hold the write lock.
+func (rc *revocationCache) prune(now time.Time) {
+	for id, expiresAt := range rc.revoked {
+		if now.After(expiresAt) {
+			delete(rc.revoked, id)
+		}
+	}
+	for id, until := range rc.checked {
+		if now.After(until) {
+			delete(rc.checked, id)
+		}
+	}
+}
+
+// isSessionRevoked checks the cache first and falls back to the revoked_sessions table.
+func (s *service) isSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	now := time.Now()
+	if revoked, cached := s.revoked.lookup(sessionID, now); cached {
+		return revoked, nil
+	}
+
+	revoked, err := s.Repository.IsSessionRevoked(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	if revoked {
+		s.revoked.markRevoked(sessionID, now.Add(s.accessTTL))
+	} else {
+		s.revoked.markValid(sessionID, now)
+	}
+	return revoked, nil
+}
+
+// revokeSessions revokes the given sessions of a user: their refresh tokens stop working,
+// their access tokens are rejected by ParseToken and their WebSocket connections are closed.
+func (s *service) revokeSessions(ctx context.Context, userID int64, reason string, sessionIDs ...string) error {
+	// An access token issued just before the revocation lives at most accessTTL.
+	expiresAt := time.Now().Add(s.accessTTL)
+
+	for _, id := range sessionIDs {
+		if err := s.Repository.RevokeSession(ctx, id, userID, reason, expiresAt); err != nil {
+			return err
+		}
+		s.revoked.markRevoked(id, expiresAt)
+	}
+
+	if s.terminator != nil && len(sessionIDs) > 0 {
+		s.terminator.TerminateSessions(sessionIDs...)
+	}
+	return nil
+}
+
+// revokeAllSessions revokes every active session of a user except the ones listed in keep.
+func (s *service) revokeAllSessions(ctx context.Context, userID int64, reason string, keep ...string) error {
+	ids, err := s.Repository.ListSessionIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	revoke := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !contains(keep, id) {
+			revoke = append(revoke, id)
+		}
+	}
+	return s.revokeSessions(ctx, userID, reason, revoke...)
+}
+
+// contains reports whether v is in list.
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package users
+
+// Roles of the users, stored in the role column and carried in the access token.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permission is something a role may be allowed to do.
+type Permission string
+
+// Permissions checked by RequirePermission.
+const (
+	// PermCreateRooms allows creating chat rooms.
+	PermCreateRooms Permission = "rooms:create"
+	// PermManageRooms allows listing every room with its creator and closing rooms.
+	PermManageRooms Permission = "rooms:manage"
+	// PermManageUsers allows changing roles, revoking sessions, deleting accounts and lifting login lockouts.
+	PermManageUsers Permission = "users:manage"
+	// PermViewAudit allows reading and exporting the authentication audit log.
+	PermViewAudit Permission = "audit:read"
+)
+
+// rolePermissions lists what each role may do. Plain users only get what every route already allows.
+var rolePermissions = map[string][]Permission{
+	RoleUser:      {},
+	RoleModerator: {PermCreateRooms, PermManageRooms},
+	RoleAdmin:     {PermCreateRooms, PermManageRooms, PermManageUsers, PermViewAudit},
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the role is allowed p. Unknown roles are allowed nothing.
+func HasPermission(role string, p Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package users
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// defaultSearchLimit is the page size of a search that does not ask for one.
+const defaultSearchLimit = 20
+
+// SearchUsers finds users by username or display name and returns their public profiles.
+// One more result than the page size is fetched to know whether there is a next page.
+func (s *service) SearchUsers(c context.Context, req *SearchUsersReq) (*SearchUsersRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	query := strings.ToLower(strings.TrimSpace(req.Query))
+	if query == "" {
+		return nil, fmt.Errorf("%w: q must not be blank", ErrInvalidRequest)
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+
+	var after *SearchCursor
+	if req.Cursor != "" {
+		cursor, err := decodeSearchCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &cursor
+	}
+
+	results, err := s.Repository.SearchUsers(ctx, query, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SearchUsersRes{Users: make([]Profile, 0, len(results))}
+	if len(results) > limit {
+		results = results[:limit]
+		res.NextCursor = encodeSearchCursor(results[limit-1].Cursor)
+	}
+	for _, r := range results {
+		res.Users = append(res.Users, r.User.Profile())
+	}
+	return res, nil
+}
+
+// encodeSearchCursor turns a cursor into an opaque string for the client.
+func encodeSearchCursor(c SearchCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Score + ":" + strconv.FormatInt(c.ID, 10)))
+}
+
+// decodeSearchCursor parses a cursor made by encodeSearchCursor.
+func decodeSearchCursor(s string) (SearchCursor, error) {
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidRequest)
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return SearchCursor{}, invalid
+	}
+	score, id, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return SearchCursor{}, invalid
+	}
+	// ParseFloat also reads NaN, infinities and hex floats, which Postgres refuses as a numeric.
+	f, err := strconv.ParseFloat(score, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return SearchCursor{}, invalid
+	}
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return SearchCursor{}, invalid
+	}
+	return SearchCursor{Score: strconv.FormatFloat(f, 'g', -1, 64), ID: userID}, nil
+}
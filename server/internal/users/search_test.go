@@ -0,0 +1,47 @@
+package users
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestDecodeSearchCursor(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name    string
+		cursor  string
+		want    SearchCursor
+		wantErr bool
+	}{
+		{name: "round trip", cursor: encodeSearchCursor(SearchCursor{Score: "1.523456", ID: 42}), want: SearchCursor{Score: "1.523456", ID: 42}},
+		{name: "integer score", cursor: raw("1:7"), want: SearchCursor{Score: "1", ID: 7}},
+		{name: "hex float is normalized", cursor: raw("0x1p-2:7"), want: SearchCursor{Score: "0.25", ID: 7}},
+		{name: "NaN", cursor: raw("NaN:7"), wantErr: true},
+		{name: "infinity", cursor: raw("Inf:7"), wantErr: true},
+		{name: "negative infinity", cursor: raw("-Infinity:7"), wantErr: true},
+		{name: "out of range", cursor: raw("1e400:7"), wantErr: true},
+		{name: "not a number", cursor: raw("abc:7"), wantErr: true},
+		{name: "missing ID", cursor: raw("1.5"), wantErr: true},
+		{name: "bad ID", cursor: raw("1.5:x"), wantErr: true},
+		{name: "not base64", cursor: "!!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSearchCursor(tt.cursor)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRequest) {
+					t.Fatalf("err = %v, want ErrInvalidRequest", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
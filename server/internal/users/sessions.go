@@ -0,0 +1,87 @@
+package users
+
+import (
+	"context"
+	"server/internal/audit"
+	"strconv"
+)
+
+// ConnectionCounter counts the open WebSockets of login sessions. It is implemented by ws.Hub.
+type ConnectionCounter interface {
+	// SessionConnections returns how many connections the session has open on this server node.
+	SessionConnections(sessionID string) int
+}
+
+// ListSessions returns the login sessions of the logged in user, most recently active first.
+func (s *service) ListSessions(c context.Context, claims *MyJWTClaims) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.Repository.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+		if s.connections != nil {
+			sessions[i].Connections = s.connections.SessionConnections(sessions[i].ID)
+		}
+	}
+	return sessions, nil
+}
+
+// DeleteSession logs out one session of the logged in user, which may be the current one.
+func (s *service) DeleteSession(c context.Context, claims *MyJWTClaims, sessionID string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	// Only the active sessions of the user can be revoked, so a guessed ID of someone else's session does nothing.
+	ids, err := s.Repository.ListSessionIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !contains(ids, sessionID) {
+		return ErrSessionNotFound
+	}
+	return s.logout(ctx, userID, sessionID)
+}
+
+// DeleteAllSessions logs out every session of the logged in user, the current one included.
+func (s *service) DeleteAllSessions(c context.Context, claims *MyJWTClaims) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	ids, err := s.Repository.ListSessionIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// The current session is revoked even when its refresh token already expired, its access token may still be valid.
+	if !contains(ids, claims.SessionID) {
+		ids = append(ids, claims.SessionID)
+	}
+	if err := s.revokeSessions(ctx, userID, RevokeReasonLogout, ids...); err != nil {
+		return err
+	}
+
+	s.recordAudit(ctx, audit.Event{
+		Type:      audit.TypeLogout,
+		UserID:    &userID,
+		SessionID: claims.SessionID,
+		Success:   true,
+		Details:   map[string]string{"sessions": "all"},
+	})
+	return nil
+}
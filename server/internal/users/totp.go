@@ -0,0 +1,276 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"server/config"
+	"server/internal/throttle"
+	"server/internal/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recoveryCodeCount is the number of recovery codes handed out when two-factor authentication is enabled.
+const recoveryCodeCount = 10
+
+// newTOTPBox creates the SecretBox of TOTP secrets from the key in the environment variable named by
+// cfg.TOTPKeyEnv, or returns nil when the variable is not set.
+func newTOTPBox(cfg config.AuthConfig) (*util.SecretBox, error) {
+	key := ""
+	if cfg.TOTPKeyEnv != "" {
+		key = os.Getenv(cfg.TOTPKeyEnv)
+	}
+	if key == "" {
+		log.Printf("totp: no encryption key in $%s, two-factor authentication cannot be set up", cfg.TOTPKeyEnv)
+		return nil, nil
+	}
+	box, err := util.NewSecretBox(key)
+	if err != nil {
+		return nil, fmt.Errorf("totp key $%s: %w", cfg.TOTPKeyEnv, err)
+	}
+	return box, nil
+}
+
+// totpContext binds a sealed TOTP secret to its user, so it cannot be copied to another account.
+func totpContext(userID int64) string {
+	return "totp:" + strconv.FormatInt(userID, 10)
+}
+
+// totpSecret returns the TOTP secret of the user in clear. Secrets enrolled before they were encrypted
+// are returned as stored.
+func (s *service) totpSecret(user User) (string, error) {
+	if !util.IsSealed(user.TOTPSecret) {
+		return user.TOTPSecret, nil
+	}
+	if s.totpBox == nil {
+		return "", fmt.Errorf("totp: secret of user %d is encrypted but no key is configured", user.ID)
+	}
+	return s.totpBox.Open(user.TOTPSecret, totpContext(user.ID))
+}
+
+// sealLegacyTOTPSecret encrypts a TOTP secret stored in clear before secrets were encrypted, once it was used.
+// Failing to do so is only logged, like rehashPassword.
+func (s *service) sealLegacyTOTPSecret(ctx context.Context, user User, secret string) {
+	if s.totpBox == nil || util.IsSealed(user.TOTPSecret) {
+		return
+	}
+	sealed, err := s.totpBox.Seal(secret, totpContext(user.ID))
+	if err == nil {
+		err = s.Repository.ReplaceTOTPSecret(ctx, user.ID, sealed)
+	}
+	if err != nil {
+		log.Printf("totp: encrypt secret of user %d: %v", user.ID, err)
+	}
+}
+
+// EnrollTOTP generates a TOTP secret for the user. Two-factor authentication is only enabled
+// once ConfirmTOTP receives a valid code, so a failed enrolment never locks the user out.
+func (s *service) EnrollTOTP(c context.Context, claims *MyJWTClaims) (*EnrollTOTPRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	if s.totpBox == nil {
+		return nil, ErrTOTPUnavailable
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.totpBox.Seal(secret, totpContext(user.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Repository.SetTOTPSecret(ctx, user.ID, sealed); err != nil {
+		return nil, err
+	}
+
+	return &EnrollTOTPRes{
+		Secret: secret,
+		URI:    util.TOTPURI(s.appName, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication when the code matches the enrolled secret,
+// and returns freshly generated recovery codes.
+func (s *service) ConfirmTOTP(c context.Context, claims *MyJWTClaims, req *ConfirmTOTPReq) (*ConfirmTOTPRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	secret, err := s.totpSecret(user)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := util.ValidateTOTP(secret, req.Code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = util.HashToken(normalizeRecoveryCode(code))
+	}
+
+	if err := s.Repository.EnableTOTP(ctx, user.ID, step, hashes); err != nil {
+		return nil, err
+	}
+	return &ConfirmTOTPRes{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP turns two-factor authentication off. It needs the password and a current code or recovery code.
+func (s *service) DisableTOTP(c context.Context, claims *MyJWTClaims, req *DisableTOTPReq) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	user, err := s.userFromClaims(ctx, claims)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+	if err := s.reauthenticate(ctx, user, req.Password, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+
+	return s.Repository.DisableTOTP(ctx, user.ID)
+}
+
+// LoginTwoFactor redeems the challenge returned by LoginUser together with a TOTP or recovery code
+// and starts the session. The challenge is single use: a wrong code means starting over with the password.
+func (s *service) LoginTwoFactor(c context.Context, req *LoginTwoFactorReq) (LoginUserRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	ut, err := s.Repository.ConsumeUserToken(ctx, TokenPurposeLoginChallenge, util.HashToken(req.Challenge))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginUserRes{}, ErrInvalidChallenge
+	}
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, ut.UserID)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	keys := []string{throttle.AccountKey(user.Email), throttle.IPKey(util.ClientInfoFrom(c).IP)}
+	if err := s.guard.Check(ctx, keys...); err != nil {
+		s.auditLoginFailed(ctx, user.Email, &user, loginMethodTwoFactor, err)
+		return LoginUserRes{}, err
+	}
+	if err := s.checkSecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			s.auditLoginFailed(ctx, user.Email, &user, loginMethodTwoFactor, err)
+			return LoginUserRes{}, s.loginFailed(ctx, keys, err)
+		}
+		return LoginUserRes{}, err
+	}
+
+	return s.loginSucceeded(ctx, user, loginMethodTwoFactor)
+}
+
+// startTwoFactorChallenge creates the short-lived challenge that LoginUser returns instead of a session.
+func (s *service) startTwoFactorChallenge(ctx context.Context, user User) (LoginUserRes, error) {
+	challenge, err := s.createUserToken(ctx, user.ID, TokenPurposeLoginChallenge, s.loginChallengeTTL)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+	return LoginUserRes{TwoFactorRequired: true, Challenge: challenge}, nil
+}
+
+// checkSecondFactor accepts either a TOTP code that was not used before or an unused recovery code.
+func (s *service) checkSecondFactor(ctx context.Context, user User, code string, recoveryCode string) error {
+	if code != "" {
+		secret, err := s.totpSecret(user)
+		if err != nil {
+			return err
+		}
+		step, ok := util.ValidateTOTP(secret, code, time.Now())
+		if !ok || step <= user.TOTPLastStep {
+			return ErrInvalidTOTPCode
+		}
+		ok, err = s.Repository.UseTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTOTPCode
+		}
+		s.sealLegacyTOTPSecret(ctx, user, secret)
+		return nil
+	}
+
+	if recoveryCode != "" {
+		ok, err := s.Repository.UseRecoveryCode(ctx, user.ID, util.HashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	return ErrInvalidTOTPCode
+}
+
+// userFromClaims loads the user the claims were issued to.
+func (s *service) userFromClaims(ctx context.Context, claims *MyJWTClaims) (User, error) {
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return User{}, err
+	}
+	return s.Repository.GetUserByID(ctx, userID)
+}
+
+// generateRecoveryCode returns a random code such as "k3m9q-7xw2p".
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in a recovery code typed by a user.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,111 @@
+package users
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"server/config"
+	"server/internal/util"
+	"testing"
+	"time"
+)
+
+func TestCheckSecondFactor(t *testing.T) {
+	t.Setenv("TEST_TOTP_KEY", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	cfg := config.Default()
+	cfg.Auth.TOTPKeyEnv = "TEST_TOTP_KEY"
+
+	const secret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	step := util.TOTPStep(time.Now())
+	code, err := util.TOTPCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongCode := "000000"
+	if wrongCode == code {
+		wrongCode = "111111"
+	}
+
+	tests := []struct {
+		name         string
+		legacy       bool  // The secret is stored in clear, as before secrets were encrypted.
+		sealedFor    int64 // The secret was sealed for another user.
+		lastStep     int64
+		code         string
+		recoveryCode string
+		wantErr      error
+		wantAnyErr   bool
+	}{
+		{name: "valid code", code: code},
+		{name: "wrong code", code: wrongCode, wantErr: ErrInvalidTOTPCode},
+		{name: "replayed code", code: code, lastStep: step, wantErr: ErrInvalidTOTPCode},
+		{name: "recovery code", recoveryCode: " ABCDE-fghij "},
+		{name: "unknown recovery code", recoveryCode: "zzzzz-zzzzz", wantErr: ErrInvalidTOTPCode},
+		{name: "nothing given", wantErr: ErrInvalidTOTPCode},
+		{name: "secret stored in clear", legacy: true, code: code},
+		{name: "secret sealed for another user", sealedFor: 2, code: code, wantAnyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepository()
+			svc, err := NewService(repo, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := svc.(*service)
+
+			stored := secret
+			if !tt.legacy {
+				owner := int64(1)
+				if tt.sealedFor != 0 {
+					owner = tt.sealedFor
+				}
+				if stored, err = s.totpBox.Seal(secret, totpContext(owner)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			user := User{ID: 1, Email: "jane@example.com", TOTPSecret: stored, TOTPEnabled: true, TOTPLastStep: tt.lastStep}
+			repo.users[user.ID] = user
+			repo.recoveryCodes[user.ID] = map[string]bool{util.HashToken("abcdefghij"): true}
+
+			err = s.checkSecondFactor(context.Background(), user, tt.code, tt.recoveryCode)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.wantAnyErr:
+				if err == nil || errors.Is(err, ErrInvalidTOTPCode) {
+					t.Fatalf("err = %v, want an internal error", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("err = %v", err)
+			}
+
+			// A code or recovery code only works once.
+			user = repo.users[user.ID]
+			if err := s.checkSecondFactor(context.Background(), user, tt.code, tt.recoveryCode); !errors.Is(err, ErrInvalidTOTPCode) {
+				t.Fatalf("second use: err = %v, want ErrInvalidTOTPCode", err)
+			}
+			if !util.IsSealed(user.TOTPSecret) {
+				t.Fatal("secret is still stored in clear")
+			}
+		})
+	}
+}
+
+func TestEnrollTOTPNeedsKey(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.TOTPKeyEnv = "TEST_TOTP_KEY_UNSET"
+	repo := newMemoryRepository(User{ID: 1, Email: "jane@example.com"})
+	svc, err := NewService(repo, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.EnrollTOTP(context.Background(), &MyJWTClaims{ID: "1"}); !errors.Is(err, ErrTOTPUnavailable) {
+		t.Fatalf("err = %v, want ErrTOTPUnavailable", err)
+	}
+}
@@ -0,0 +1,138 @@
+package users
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"server/internal/throttle"
+	"server/internal/util"
+	"server/internal/validation"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorCode is the HTTP status and the stable, machine-readable code returned for a domain error.
+type errorCode struct {
+	err    error
+	status int
+	code   string
+}
+
+// errorCodes maps domain errors to responses. Clients should match on the code, the message may change.
+// The first entry that matches with errors.Is wins.
+var errorCodes = []errorCode{
+	{ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{ErrInvalidProfile, http.StatusBadRequest, "invalid_profile"},
+	{ErrInvalidToken, http.StatusBadRequest, "invalid_token"},
+	{ErrOIDCInvalidState, http.StatusBadRequest, "invalid_sso_state"},
+	{util.ErrWeakPassword, http.StatusBadRequest, "weak_password"},
+	{util.ErrBreachedPassword, http.StatusBadRequest, "breached_password"},
+
+	{ErrNotAuthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{ErrInvalidPassword, http.StatusUnauthorized, "invalid_password"},
+	{ErrInvalidTOTPCode, http.StatusUnauthorized, "invalid_two_factor_code"},
+	{ErrInvalidChallenge, http.StatusUnauthorized, "invalid_challenge"},
+	{ErrInvalidRefreshToken, http.StatusUnauthorized, "invalid_refresh_token"},
+	{ErrRefreshTokenReused, http.StatusUnauthorized, "refresh_token_reused"},
+	{ErrSessionRevoked, http.StatusUnauthorized, "session_revoked"},
+
+	{ErrEmailNotVerified, http.StatusForbidden, "email_not_verified"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrInsufficientScope, http.StatusForbidden, "insufficient_scope"},
+	{ErrSessionRequired, http.StatusForbidden, "session_required"},
+	{ErrOIDCAccountNotLinked, http.StatusForbidden, "account_not_linked"},
+	{ErrAccountDeleted, http.StatusForbidden, "account_deleted"},
+	{ErrInvalidFormToken, http.StatusForbidden, "invalid_form_token"},
+	{ErrTooManyBots, http.StatusForbidden, "too_many_bots"},
+	{ErrContactRequestNotAllowed, http.StatusForbidden, "contact_request_not_allowed"},
+
+	{ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{ErrOIDCDisabled, http.StatusNotFound, "sso_disabled"},
+	{ErrTOTPUnavailable, http.StatusNotFound, "two_factor_unavailable"},
+	{ErrAccessTokenNotFound, http.StatusNotFound, "token_not_found"},
+	{ErrBotNotFound, http.StatusNotFound, "bot_not_found"},
+	{ErrSessionNotFound, http.StatusNotFound, "session_not_found"},
+	{ErrBlockNotFound, http.StatusNotFound, "block_not_found"},
+	{ErrContactNotFound, http.StatusNotFound, "contact_not_found"},
+	{ErrContactRequestNotFound, http.StatusNotFound, "contact_request_not_found"},
+
+	{ErrEmailTaken, http.StatusConflict, "email_taken"},
+	{ErrUsernameTaken, http.StatusConflict, "username_taken"},
+	{ErrTOTPAlreadyEnabled, http.StatusConflict, "two_factor_already_enabled"},
+	{ErrTOTPNotEnrolled, http.StatusConflict, "two_factor_not_enrolled"},
+	{ErrAlreadyContacts, http.StatusConflict, "already_contacts"},
+	{ErrContactRequestExists, http.StatusConflict, "contact_request_exists"},
+
+	{throttle.ErrLimited, http.StatusTooManyRequests, "too_many_attempts"},
+}
+
+// ErrorResponse is the JSON body of every error answered by the users handlers.
+// Fields lists the invalid fields when Code is "validation_failed".
+type ErrorResponse struct {
+	Code   string                  `json:"code"`
+	Error  string                  `json:"error"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
+
+// WriteError answers the request with the status and code of a domain error.
+// Other errors are logged and answered with HTTP 500 without their text, which may contain internal details.
+// Throttled requests also get a Retry-After header.
+func WriteError(c *gin.Context, err error) {
+	status, res := errorResponse(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", c.Request.Method, c.FullPath(), err)
+	}
+	var limited *throttle.LimitedError
+	if errors.As(err, &limited) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+	}
+	c.JSON(status, res)
+}
+
+// AbortWithError is WriteError for middlewares: it also stops the remaining handlers.
+func AbortWithError(c *gin.Context, err error) {
+	WriteError(c, err)
+	c.Abort()
+}
+
+// errorResponse looks up the status and body for err.
+func errorResponse(err error) (int, ErrorResponse) {
+	if errors.Is(err, ErrInvalidRequest) {
+		if validation.IsBodyTooLarge(err) {
+			return http.StatusRequestEntityTooLarge, ErrorResponse{Code: "request_too_large", Error: validation.ErrBodyTooLarge.Error()}
+		}
+		if fields := validation.Fields(err); len(fields) > 0 {
+			return http.StatusBadRequest, ErrorResponse{Code: "validation_failed", Error: err.Error(), Fields: fields}
+		}
+	}
+
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return ec.status, ErrorResponse{Code: ec.code, Error: err.Error()}
+		}
+	}
+	return http.StatusInternalServerError, ErrorResponse{Code: "internal_error", Error: "internal server error"}
+}
+
+// requestError is a request body or parameter that could not be read or failed validation.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return ErrInvalidRequest.Error() + ": " + validation.Message(e.err)
+}
+
+// Unwrap lets errors.Is match ErrInvalidRequest and errors.As reach the binding error.
+func (e *requestError) Unwrap() []error {
+	return []error{ErrInvalidRequest, e.err}
+}
+
+// InvalidRequest wraps an error from binding or checking the request so WriteError answers it with HTTP 400,
+// listing the invalid fields, or HTTP 413 when the body is too large.
+func InvalidRequest(err error) error {
+	return &requestError{err: err}
+}
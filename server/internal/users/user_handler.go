@@ -18,15 +18,26 @@
 //  - It binds the JSON data from the request body to a CreateUserReq struct.
 //  - If there is an error while binding the JSON data, it returns a HTTP response with status code 400 (Bad Request) and an error message.
 //  - It calls the CreateUser method of the Service interface with the context and the CreateUserReq struct as parameters.
-//  - If there is an error while calling the CreateUser method, it returns a HTTP response with status code 500 (Internal Server Error) and an error message.
+//  - If there is an error while calling the CreateUser method, WriteError answers with the matching status and error code,
+//    e.g. 409 (Conflict) and "email_taken", or 500 (Internal Server Error) without the details of unexpected errors.
 //  - If the CreateUser method returns a result successfully, it returns a HTTP response with status code 200 (OK) and the result.
 
 package users
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
 	"net/http"
+	"server/internal/throttle"
+	"server/internal/util"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // Handler struct
@@ -43,13 +54,13 @@ func NewHandler(s Service) *Handler {
 func (h *Handler) CreateUser(c *gin.Context) {
 	var u CreateUserReq
 	if err := c.ShouldBindJSON(&u); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		WriteError(c, InvalidRequest(err))
 		return
 	}
 
 	res, err := h.Service.CreateUser(c.Request.Context(), &u)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		WriteError(c, err)
 		return
 	}
 
@@ -60,14 +71,19 @@ func (h *Handler) CreateUser(c *gin.Context) {
 func (h *Handler) LoginUser(c *gin.Context) {
 	var u LoginUserReq
 	if err := c.ShouldBindJSON(&u); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		WriteError(c, InvalidRequest(err))
+		return
 	}
 	res, err := h.Service.LoginUser(c.Request.Context(), &u)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		WriteError(c, err)
+		return
+	}
+	if res.TwoFactorRequired {
+		c.JSON(http.StatusOK, res)
 		return
 	}
-	c.SetCookie("jwt", res.access_token, 3600, "/", "localhost", false, true)
+	setSessionCookies(c, res)
 
 	user := LoginUserRes{
 		Username: res.Username,
@@ -76,7 +92,1013 @@ func (h *Handler) LoginUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// LoginTwoFactor method
+// It completes a login that LoginUser answered with a two-factor challenge and sets the session cookies.
+func (h *Handler) LoginTwoFactor(c *gin.Context) {
+	var req LoginTwoFactorReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.LoginTwoFactor(c.Request.Context(), &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+	setSessionCookies(c, res)
+
+	c.JSON(http.StatusOK, LoginUserRes{
+		Username: res.Username,
+		ID:       res.ID,
+	})
+}
+
+// RequestMagicLink method
+// It mails a passwordless login link. It answers HTTP 202 (Accepted) whether or not the email is registered,
+// and HTTP 429 (Too Many Requests) when the address or the client IP asked too often.
+func (h *Handler) RequestMagicLink(c *gin.Context) {
+	var req MagicLinkReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	err := h.Service.RequestMagicLink(c.Request.Context(), req.Email)
+	if errors.Is(err, throttle.ErrLimited) {
+		WriteError(c, err)
+		return
+	}
+	if err != nil {
+		log.Printf("magic link: %v", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a login link has been sent"})
+}
+
+// magicLinkPage is the page an emailed login link opens. Its button posts the token to LoginMagicLink,
+// relative to the page so the token does not travel in a URL again, with the CSRF token of the page.
+var magicLinkPage = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>Log in</title>
+</head>
+<body>
+<form method="post" action="redeem">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// MagicLinkPage method
+// It answers an emailed login link with a page asking to confirm the login. Opening the link does not use it,
+// so mail scanners that fetch links cannot burn it.
+func (h *Handler) MagicLinkPage(c *gin.Context) {
+	var req LoginMagicLinkReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+	csrf, err := setFormCSRF(c)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	writeConfirmationPage(c, magicLinkPage, LoginMagicLinkReq{Token: req.Token, CSRF: csrf})
+}
+
+// LoginMagicLink method
+// It redeems the token of a login link, posted as a form field by MagicLinkPage or in a JSON body, and sets
+// the session cookies. Accounts with two-factor authentication get a challenge for /login/2fa instead, like LoginUser.
+// Forms need the CSRF token of MagicLinkPage, otherwise another site could post its own link and log the browser
+// into the wrong account.
+func (h *Handler) LoginMagicLink(c *gin.Context) {
+	var req LoginMagicLinkReq
+	if err := c.ShouldBind(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+	if err := checkFormCSRF(c, req.CSRF); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	res, err := h.Service.LoginMagicLink(c.Request.Context(), req.Token)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+	if res.TwoFactorRequired {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	setSessionCookies(c, res)
+
+	c.JSON(http.StatusOK, LoginUserRes{
+		Username: res.Username,
+		ID:       res.ID,
+	})
+}
+
+// OIDCLogin method
+// It redirects the browser to the identity provider and keeps the login state in a short-lived cookie.
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	res, err := h.Service.OIDCLogin(c.Request.Context())
+	if errors.Is(err, ErrOIDCDisabled) {
+		WriteError(c, err)
+		return
+	}
+	if err != nil {
+		log.Printf("oidc login: %v", err)
+		c.JSON(http.StatusBadGateway, ErrorResponse{Code: "sso_unavailable", Error: "identity provider unavailable"})
+		return
+	}
+
+	c.SetCookie("oidc_state", res.State, int(OIDCStateTTL.Seconds()), "/oidc", "localhost", false, true)
+	c.Redirect(http.StatusFound, res.URL)
+}
+
+// OIDCCallback method
+// It finishes the login the identity provider redirected back from and sets the session cookies.
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	if idpErr := c.Query("error"); idpErr != "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Code: "sso_failed", Error: idpErr + ": " + c.Query("error_description")})
+		return
+	}
+
+	var req OIDCCallbackReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+	req.StoredState, _ = c.Cookie("oidc_state")
+	c.SetCookie("oidc_state", "", -1, "/oidc", "localhost", false, true)
+
+	res, err := h.Service.OIDCCallback(c.Request.Context(), &req)
+	switch {
+	case errors.Is(err, ErrOIDCDisabled), errors.Is(err, ErrOIDCInvalidState), errors.Is(err, ErrOIDCAccountNotLinked):
+		WriteError(c, err)
+		return
+	case err != nil:
+		// Failures at the identity provider or in the ID token are not detailed to the client.
+		log.Printf("oidc callback: %v", err)
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Code: "sso_failed", Error: "single sign-on failed"})
+		return
+	}
+	setSessionCookies(c, res)
+
+	if res.redirect_to != "" {
+		c.Redirect(http.StatusFound, res.redirect_to)
+		return
+	}
+	c.JSON(http.StatusOK, LoginUserRes{
+		Username: res.Username,
+		ID:       res.ID,
+	})
+}
+
+// RefreshToken method
+// It rotates the "refresh_token" cookie and sets a new "jwt" access token cookie.
+// A missing, expired or reused refresh token results in HTTP 401 (Unauthorized).
+func (h *Handler) RefreshToken(c *gin.Context) {
+	refreshToken, err := c.Cookie("refresh_token")
+	if err != nil || refreshToken == "" {
+		WriteError(c, ErrInvalidRefreshToken)
+		return
+	}
+
+	res, err := h.Service.RefreshToken(c.Request.Context(), refreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		clearSessionCookies(c)
+		WriteError(c, err)
+		return
+	}
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+	setSessionCookies(c, res)
+
+	c.JSON(http.StatusOK, LoginUserRes{
+		Username: res.Username,
+		ID:       res.ID,
+	})
+}
+
+// LogoutUser method
+// It revokes the current session on the server, which also closes its WebSocket connections,
+// and clears the session cookies.
 func (h *Handler) LogoutUser(c *gin.Context) {
-	c.SetCookie("jwt", "", -1, "", "", false, true)
+	refreshToken, _ := c.Cookie("refresh_token")
+	if err := h.Service.Logout(c.Request.Context(), tokenFromRequest(c), refreshToken); err != nil {
+		log.Printf("logout: %v", err)
+	}
+
+	clearSessionCookies(c)
 	c.JSON(http.StatusOK, gin.H{"message": "Logged Out Successfully"})
 }
+
+// ChangePassword method
+// It requires the current password and logs out every other session of the user.
+func (h *Handler) ChangePassword(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req ChangePasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	err := h.Service.ChangePassword(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password Changed Successfully"})
+}
+
+// ChangeEmail method
+// It requires the current password, and a two-factor code when enabled, and sends a verification link to the new address.
+func (h *Handler) ChangeEmail(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req ChangeEmailReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	err := h.Service.ChangeEmail(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email Changed Successfully, please verify the new address"})
+}
+
+// DeleteAccount method
+// It schedules the account for deletion, logs out every session and clears the session cookies.
+func (h *Handler) DeleteAccount(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req DeleteAccountReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.DeleteAccount(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusAccepted, res)
+}
+
+// ExportAccount method
+// It downloads the personal data of the logged in user, as a ZIP archive or with ?format=json as one JSON document.
+func (h *Handler) ExportAccount(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req ExportAccountReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	export, err := h.Service.ExportAccount(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	filename := fmt.Sprintf("go-chat-export-%s-%s", export.Account.Username, export.ExportedAt.Format("20060102"))
+	if req.Format == "json" {
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		c.IndentedJSON(http.StatusOK, export)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	if err := export.WriteZip(c.Writer); err != nil {
+		log.Printf("%s %s: %v", c.Request.Method, c.FullPath(), err)
+	}
+}
+
+// GetMe method
+// It returns the profile and account settings of the logged in user.
+func (h *Handler) GetMe(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.GetMe(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// UpdateProfile method
+// It changes the display name, bio, avatar URL or time zone; fields missing from the body keep their value.
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req UpdateProfileReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.UpdateProfile(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetProfile method
+// It returns the public profile of the user in the ":id" path parameter.
+func (h *Handler) GetProfile(c *gin.Context) {
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.Service.GetProfile(c.Request.Context(), userID)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetPresence method
+// It returns whether the user in the ":id" path parameter is online, away or offline, and when they were last seen.
+func (h *Handler) GetPresence(c *gin.Context) {
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.Service.GetPresence(c.Request.Context(), userID)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// SearchUsers method
+// It returns a page of public profiles matching the "q" query parameter; "cursor" asks for the next page.
+func (h *Handler) SearchUsers(c *gin.Context) {
+	var req SearchUsersReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.SearchUsers(c.Request.Context(), &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// ListAccessTokens method
+// It lists the personal access tokens of the logged in user, without their values.
+func (h *Handler) ListAccessTokens(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.ListAccessTokens(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// CreateAccessToken method
+// It creates a personal access token and returns its value, which cannot be read again later.
+func (h *Handler) CreateAccessToken(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req CreateAccessTokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.CreateAccessToken(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+// DeleteAccessToken method
+// It revokes the personal access token in the ":id" path parameter.
+func (h *Handler) DeleteAccessToken(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.DeleteAccessToken(c.Request.Context(), claims, id); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token Deleted Successfully"})
+}
+
+// ListSessions method
+// It lists the login sessions of the logged in user with their device, last activity and open WebSockets.
+func (h *Handler) ListSessions(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.ListSessions(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DeleteSession method
+// It logs out the session in the ":id" path parameter and closes its WebSockets.
+// Logging out the current session also clears its cookies.
+func (h *Handler) DeleteSession(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	sessionID := c.Param("id")
+
+	if err := h.Service.DeleteSession(c.Request.Context(), claims, sessionID); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	if sessionID == claims.SessionID {
+		clearSessionCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session Logged Out Successfully"})
+}
+
+// DeleteAllSessions method
+// It logs out every session of the logged in user, this one included, and closes their WebSockets.
+func (h *Handler) DeleteAllSessions(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	if err := h.Service.DeleteAllSessions(c.Request.Context(), claims); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged Out Everywhere Successfully"})
+}
+
+// ListBlocks method
+// It lists the users blocked or muted by the logged in user.
+func (h *Handler) ListBlocks(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.ListBlocks(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// SetBlock method
+// It blocks or mutes the user in the ":id" path parameter.
+func (h *Handler) SetBlock(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	var req SetBlockReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	if err := h.Service.SetBlock(c.Request.Context(), claims, userID, &req); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User Blocked Successfully"})
+}
+
+// DeleteBlock method
+// It unblocks or unmutes the user in the ":id" path parameter.
+func (h *Handler) DeleteBlock(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.DeleteBlock(c.Request.Context(), claims, userID); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User Unblocked Successfully"})
+}
+
+// ListContacts method
+// It lists the contacts of the logged in user.
+func (h *Handler) ListContacts(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.ListContacts(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// ListContactRequests method
+// It lists the pending friend requests received and sent by the logged in user.
+func (h *Handler) ListContactRequests(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.ListContactRequests(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// SendContactRequest method
+// It sends a friend request to the user in the body, or accepts the one they already sent.
+func (h *Handler) SendContactRequest(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req SendContactRequestReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.SendContactRequest(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// AcceptContactRequest method
+// It accepts the friend request sent by the user in the ":id" path parameter.
+func (h *Handler) AcceptContactRequest(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.AcceptContactRequest(c.Request.Context(), claims, userID); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend Request Accepted Successfully"})
+}
+
+// DeclineContactRequest method
+// It declines the friend request sent by the user in the ":id" path parameter.
+func (h *Handler) DeclineContactRequest(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.DeclineContactRequest(c.Request.Context(), claims, userID); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend Request Declined Successfully"})
+}
+
+// CancelContactRequest method
+// It withdraws the friend request sent to the user in the ":id" path parameter.
+func (h *Handler) CancelContactRequest(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.CancelContactRequest(c.Request.Context(), claims, userID); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend Request Cancelled Successfully"})
+}
+
+// DeleteContact method
+// It removes the user in the ":id" path parameter from the contacts.
+func (h *Handler) DeleteContact(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+	userID, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.DeleteContact(c.Request.Context(), claims, userID); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact Deleted Successfully"})
+}
+
+// idParam reads the ":id" path parameter, answering HTTP 400 when it is not a number.
+func idParam(c *gin.Context) (int64, bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		WriteError(c, InvalidRequest(errors.New("id must be a number")))
+		return 0, false
+	}
+	return userID, true
+}
+
+// formCSRFCookie holds the CSRF token of a confirmation page opened from an emailed link.
+const formCSRFCookie = "form_csrf"
+
+// formCSRFTTL is how long a confirmation page can be posted after it was opened.
+const formCSRFTTL = 15 * time.Minute
+
+// writeConfirmationPage renders the page of an emailed link. The page is not cached, framed or
+// given as a referrer, since its form holds the token of the link.
+func writeConfirmationPage(c *gin.Context, page *template.Template, data any) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := page.Execute(c.Writer, data); err != nil {
+		log.Printf("%s page: %v", c.FullPath(), err)
+	}
+}
+
+// setFormCSRF creates the CSRF token of a confirmation page, whose form posts back to the same path.
+// The token is also stored in a SameSite=Strict cookie for that path, which browsers leave out of forms
+// posted by another site.
+func setFormCSRF(c *gin.Context) (string, error) {
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(formCSRFCookie, token, int(formCSRFTTL.Seconds()), c.Request.URL.Path, "localhost", false, true)
+	return token, nil
+}
+
+// checkFormCSRF returns ErrInvalidFormToken when a form was not posted by the page of setFormCSRF.
+// JSON bodies pass: browsers only send them to another site after a CORS preflight, which this server refuses.
+// The cookie is cleared, each page can be posted once.
+func checkFormCSRF(c *gin.Context, posted string) error {
+	if c.ContentType() == binding.MIMEJSON {
+		return nil
+	}
+	cookie, err := c.Cookie(formCSRFCookie)
+	c.SetCookie(formCSRFCookie, "", -1, c.Request.URL.Path, "localhost", false, true)
+	if err != nil || posted == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(posted)) != 1 {
+		return ErrInvalidFormToken
+	}
+	return nil
+}
+
+// setSessionCookies stores the access and refresh tokens of res in HTTP-only cookies.
+// They are SameSite=Lax: sent when following a link from another site, not with its forms or scripts.
+func setSessionCookies(c *gin.Context, res LoginUserRes) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("jwt", res.access_token, int(res.access_ttl.Seconds()), "/", "localhost", false, true)
+	c.SetCookie("refresh_token", res.refresh_token, int(res.refresh_ttl.Seconds()), "/", "localhost", false, true)
+}
+
+// clearSessionCookies expires both session cookies.
+func clearSessionCookies(c *gin.Context) {
+	c.SetCookie("jwt", "", -1, "/", "localhost", false, true)
+	c.SetCookie("refresh_token", "", -1, "/", "localhost", false, true)
+}
+
+// VerifyEmail method
+// It redeems the token from the verification link, given as a "token" query parameter or JSON body.
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailReq
+	if err := c.ShouldBind(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	err := h.Service.VerifyEmail(c.Request.Context(), req.Token)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email Verified Successfully"})
+}
+
+// ResendVerification method
+// It always answers HTTP 202 (Accepted) so the response does not reveal whether the email is registered.
+func (h *Handler) ResendVerification(c *gin.Context) {
+	var req ResendVerificationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	err := h.Service.ResendVerification(c.Request.Context(), req.Email)
+	if errors.Is(err, throttle.ErrLimited) {
+		WriteError(c, err)
+		return
+	}
+	if err != nil {
+		log.Printf("resend verification: %v", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is not verified yet, a verification email has been sent"})
+}
+
+// EnrollTOTP method
+// It returns a new TOTP secret and otpauth:// URI. Nothing changes for the login until ConfirmTOTP succeeds.
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	res, err := h.Service.EnrollTOTP(c.Request.Context(), claims)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// ConfirmTOTP method
+// It enables two-factor authentication and returns the recovery codes, which are shown only once.
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req ConfirmTOTPReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	res, err := h.Service.ConfirmTOTP(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DisableTOTP method
+// It turns two-factor authentication off after checking the password and a code.
+func (h *Handler) DisableTOTP(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		WriteError(c, ErrNotAuthenticated)
+		return
+	}
+
+	var req DisableTOTPReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	err := h.Service.DisableTOTP(c.Request.Context(), claims, &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-Factor Authentication Disabled"})
+}
+
+// ForgotPassword method
+// It always answers HTTP 202 (Accepted) so the response does not reveal whether the email is registered.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+
+	err := h.Service.ForgotPassword(c.Request.Context(), req.Email)
+	if errors.Is(err, throttle.ErrLimited) {
+		WriteError(c, err)
+		return
+	}
+	if err != nil {
+		log.Printf("forgot password: %v", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+// passwordResetPage is the page an emailed password reset link opens. Its form posts the token, the new
+// password and the CSRF token of the page to ResetPassword, relative to the page like magicLinkPage.
+var passwordResetPage = template.Must(template.New("password-reset").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>Reset your password</title>
+</head>
+<body>
+<form method="post" action="reset">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+</body>
+</html>
+`))
+
+// PasswordResetPage method
+// It answers an emailed password reset link with a form asking for the new password.
+func (h *Handler) PasswordResetPage(c *gin.Context) {
+	var req PasswordResetPageReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+	csrf, err := setFormCSRF(c)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	writeConfirmationPage(c, passwordResetPage, ResetPasswordReq{Token: req.Token, CSRF: csrf})
+}
+
+// ResetPassword method
+// It sets a new password with the token from a password reset email and logs out every session.
+// Forms need the CSRF token of PasswordResetPage.
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordReq
+	if err := c.ShouldBind(&req); err != nil {
+		WriteError(c, InvalidRequest(err))
+		return
+	}
+	if err := checkFormCSRF(c, req.CSRF); err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	err := h.Service.ResetPassword(c.Request.Context(), &req)
+	if err != nil {
+		WriteError(c, err)
+		return
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Password Reset Successfully"})
+}
+
+// JWKS method
+// It publishes the public signing keys so other services can verify session tokens.
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.Service.JWKS())
+}
@@ -0,0 +1,61 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckFormCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		contentType string
+		cookie      string
+		posted      string
+		wantErr     error
+	}{
+		{name: "page token", contentType: "application/x-www-form-urlencoded", cookie: "abc", posted: "abc"},
+		{name: "json body", contentType: "application/json"},
+		{name: "no cookie", contentType: "application/x-www-form-urlencoded", posted: "abc", wantErr: ErrInvalidFormToken},
+		{name: "no posted token", contentType: "application/x-www-form-urlencoded", cookie: "abc", wantErr: ErrInvalidFormToken},
+		{name: "other token", contentType: "application/x-www-form-urlencoded", cookie: "abc", posted: "abd", wantErr: ErrInvalidFormToken},
+		{name: "plain text", contentType: "text/plain", cookie: "abc", wantErr: ErrInvalidFormToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/login/magic-link/redeem", strings.NewReader(""))
+			c.Request.Header.Set("Content-Type", tt.contentType)
+			if tt.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: formCSRFCookie, Value: tt.cookie})
+			}
+
+			if err := checkFormCSRF(c, tt.posted); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetFormCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/login/magic-link/redeem?token=x", nil)
+
+	token, err := setFormCSRF(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != token || cookies[0].SameSite != http.SameSiteStrictMode || cookies[0].Path != "/login/magic-link/redeem" {
+		t.Fatalf("cookies = %+v, want a SameSite=Strict cookie holding %q for the page", cookies, token)
+	}
+}
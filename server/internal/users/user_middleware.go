@@ -0,0 +1,126 @@
+package users
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaimsKey is the gin context key under which RequireAuth stores the authenticated *MyJWTClaims.
+const ClaimsKey = "claims"
+
+// RequireAuth is a gin middleware that validates the "jwt" cookie or a Bearer Authorization header
+// issued by LoginUser and stores the parsed claims in the context.
+// Requests without a valid token are aborted with HTTP 401 (Unauthorized).
+func (h *Handler) RequireAuth(c *gin.Context) {
+	token := tokenFromRequest(c)
+	if token == "" {
+		AbortWithError(c, ErrNotAuthenticated)
+		return
+	}
+
+	claims, err := h.Service.ParseToken(c.Request.Context(), token)
+	if errors.Is(err, ErrSessionRevoked) {
+		AbortWithError(c, err)
+		return
+	}
+	if err != nil {
+		AbortWithError(c, ErrNotAuthenticated)
+		return
+	}
+
+	c.Set(ClaimsKey, claims)
+	c.Next()
+}
+
+// RequireVerifiedEmail is a gin middleware, used after RequireAuth, that only lets through users
+// whose email address is verified. It matters when unverified accounts may log in with limited access.
+func (h *Handler) RequireVerifiedEmail(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		AbortWithError(c, ErrNotAuthenticated)
+		return
+	}
+	if !claims.EmailVerified {
+		AbortWithError(c, ErrEmailNotVerified)
+		return
+	}
+	c.Next()
+}
+
+// RequirePermission returns a gin middleware, used after RequireAuth, that only lets through users
+// whose role grants p. The role is read from the access token, so a changed role applies from the next token on.
+func (h *Handler) RequirePermission(p Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok {
+			AbortWithError(c, ErrNotAuthenticated)
+			return
+		}
+		if !HasPermission(claims.Role, p) {
+			AbortWithError(c, ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope returns a gin middleware, used after RequireAuth, that only lets personal access tokens through
+// when they have the scope. Login sessions are not limited by scopes.
+func (h *Handler) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok {
+			AbortWithError(c, ErrNotAuthenticated)
+			return
+		}
+		if !claims.HasScope(scope) {
+			AbortWithError(c, ErrInsufficientScope)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession is a gin middleware, used after RequireAuth, that refuses personal access tokens.
+// It guards the account settings and administration, which only a logged in user may change.
+func (h *Handler) RequireSession(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok {
+		AbortWithError(c, ErrNotAuthenticated)
+		return
+	}
+	if claims.IsAccessToken() {
+		AbortWithError(c, ErrSessionRequired)
+		return
+	}
+	c.Next()
+}
+
+// GetClaims returns the claims stored by RequireAuth, if any.
+func GetClaims(c *gin.Context) (*MyJWTClaims, bool) {
+	v, ok := c.Get(ClaimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := v.(*MyJWTClaims)
+	return claims, ok
+}
+
+// tokenFromRequest returns the bearer token from the Authorization header, falling back to the "jwt" cookie.
+func tokenFromRequest(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	token, err := c.Cookie("jwt")
+	if err != nil {
+		return ""
+	}
+	return token
+}
@@ -3,10 +3,114 @@ package users
 
 import (
 	"context"
+	"errors"
+	"io"
+	"server/internal/audit"
+	"server/internal/throttle"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or revoked.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The whole token family is revoked when this happens.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrSessionRevoked is returned when a token belongs to a session that was logged out or revoked.
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrInvalidPassword is returned when the current password given for a sensitive change is wrong.
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrInvalidToken is returned when a single-use token from an email is unknown, used or expired.
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrInvalidFormToken is returned when a form posted from a confirmation page lacks the page's CSRF token,
+	// e.g. because another site posted it.
+	ErrInvalidFormToken = errors.New("the page expired, open the link from the email again")
+	// ErrEmailNotVerified is returned by LoginUser for unverified accounts when they are blocked by configuration.
+	ErrEmailNotVerified = errors.New("email address not verified")
+	// ErrInvalidTOTPCode is returned when a two-factor code or recovery code is wrong or was already used.
+	ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+	// ErrTOTPAlreadyEnabled is returned when enrolling an account that already uses two-factor authentication.
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPUnavailable is returned when setting up two-factor authentication without a TOTP key configured.
+	ErrTOTPUnavailable = errors.New("two-factor authentication is not available on this server")
+	// ErrTOTPNotEnrolled is returned when confirming or disabling two-factor authentication that was never set up.
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication is not set up")
+	// ErrOIDCDisabled is returned by the OpenID Connect login when it is not configured.
+	ErrOIDCDisabled = errors.New("single sign-on is not enabled")
+	// ErrOIDCInvalidState is returned when the callback's state does not match the login that started it.
+	ErrOIDCInvalidState = errors.New("invalid single sign-on state")
+	// ErrOIDCAccountNotLinked is returned when no account may be linked to or created for the identity.
+	ErrOIDCAccountNotLinked = errors.New("no account is linked to this identity")
+	// ErrUserNotFound is returned by the repository when no user matches a lookup.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrEmailTaken is returned when an email address already belongs to another account.
+	ErrEmailTaken = errors.New("email address is already in use")
+	// ErrUsernameTaken is returned when a username already belongs to another account.
+	ErrUsernameTaken = errors.New("username is already in use")
+	// ErrInvalidCredentials is returned by LoginUser for an unknown email or a wrong password,
+	// without telling which one it was.
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrInvalidChallenge is returned when the challenge of a two-factor login is unknown, used or expired.
+	ErrInvalidChallenge = errors.New("invalid or expired login challenge")
+	// ErrNotAuthenticated is returned when a request needs a session token and has none or an invalid one.
+	ErrNotAuthenticated = errors.New("not authenticated")
+	// ErrInvalidRequest is returned, wrapped with the reason, when a request body or parameter cannot be read.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrForbidden is returned when the role of the logged in user does not allow the request.
+	ErrForbidden = errors.New("forbidden")
+	// ErrInsufficientScope is returned when a personal access token lacks the scope a route needs.
+	ErrInsufficientScope = errors.New("the access token does not have the required scope")
+	// ErrSessionRequired is returned when a personal access token is used on a route reserved to logged in sessions.
+	ErrSessionRequired = errors.New("this route needs a login session, not an access token")
+	// ErrAccessTokenNotFound is returned when a personal access token does not exist or belongs to someone else.
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	// ErrBotNotFound is returned when a bot does not exist or belongs to someone else.
+	ErrBotNotFound = errors.New("bot not found")
+	// ErrTooManyBots is returned when a user already owns as many bots as allowed.
+	ErrTooManyBots = errors.New("too many bots")
+	// ErrBlockNotFound is returned when removing a block or mute that does not exist.
+	ErrBlockNotFound = errors.New("user is not blocked or muted")
+	// ErrSessionNotFound is returned when a session does not exist, has ended or belongs to someone else.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrContactNotFound is returned when removing a contact who is not one.
+	ErrContactNotFound = errors.New("contact not found")
+	// ErrContactRequestNotFound is returned when no pending friend request matches.
+	ErrContactRequestNotFound = errors.New("friend request not found")
+	// ErrAlreadyContacts is returned when sending a friend request to a contact.
+	ErrAlreadyContacts = errors.New("already contacts")
+	// ErrContactRequestExists is returned when a friend request to the same user is already pending.
+	ErrContactRequestExists = errors.New("friend request already sent")
+	// ErrContactRequestNotAllowed is returned when the recipient cannot get friend requests from the sender,
+	// e.g. because one of them blocked the other or the recipient is a bot.
+	ErrContactRequestNotAllowed = errors.New("friend request not allowed")
+	// ErrInvalidProfile is returned, wrapped with the reason, when a profile update is rejected.
+	ErrInvalidProfile = errors.New("invalid profile")
+	// ErrAccountDeleted is returned when logging in to an account an administrator deleted.
+	ErrAccountDeleted = errors.New("account deleted")
+)
+
+// Purposes of the single-use tokens stored in the user_tokens table.
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeLoginChallenge    = "login_challenge"
+	TokenPurposeMagicLink         = "magic_link"
+)
+
+// Reasons recorded in the revoked_sessions table.
+const (
+	RevokeReasonLogout         = "logout"
+	RevokeReasonPasswordChange = "password_change"
+	RevokeReasonPasswordReset  = "password_reset"
+	RevokeReasonAdmin          = "admin"
+	RevokeReasonRefreshReuse   = "refresh_token_reuse"
+	RevokeReasonAccountDeleted = "account_deleted"
+	RevokeReasonRoleChange     = "role_change"
+)
+
 // A User represents a single user in the database, with a unique ID, username, email, and password.
 type User struct {
 	// ID is a unique number for the user.
@@ -18,16 +122,213 @@ type User struct {
 	// Email is the user's email address.
 	Email string `json:"email" db:"email"`
 
-	// Password is the user's chosen password.
-	Password string `json:"password" db:"password"`
+	// Password is the hash of the user's chosen password, it is never serialized.
+	Password string `json:"-" db:"password"`
+
+	// EmailVerified is set once the user opened the link sent to Email.
+	EmailVerified bool `json:"email_verified" db:"email_verified"`
+
+	// TOTPSecret is the base32 TOTP secret, set during enrolment. It is stored sealed by the service's
+	// util.SecretBox, except for secrets enrolled before encryption; read it with service.totpSecret.
+	TOTPSecret string `json:"-" db:"totp_secret"`
+
+	// TOTPEnabled is set once the user confirmed the enrolment with a valid code.
+	TOTPEnabled bool `json:"totp_enabled" db:"totp_enabled"`
+
+	// TOTPLastStep is the time step of the last accepted code, used to reject replays.
+	TOTPLastStep int64 `json:"-" db:"totp_last_step"`
+
+	// DisplayName is the name shown to other users, the username is used when it is empty.
+	DisplayName string `json:"display_name" db:"display_name"`
+
+	// Bio is a short text the user writes about themselves.
+	Bio string `json:"bio" db:"bio"`
+
+	// AvatarURL is an http(s) link to the user's picture.
+	AvatarURL string `json:"avatar_url" db:"avatar_url"`
+
+	// Timezone is an IANA time zone name such as "Europe/Paris".
+	Timezone string `json:"timezone" db:"timezone"`
+
+	// UpdatedAt is when the profile or email was last changed.
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// DeleteAfter is when the account will be purged, nil unless the user or an administrator deleted it.
+	DeleteAfter *time.Time `json:"delete_after,omitempty" db:"delete_after"`
+
+	// DeletedByAdmin is set when an administrator deleted the account. Logging in does not cancel such a deletion.
+	DeletedByAdmin bool `json:"-" db:"deleted_by_admin"`
+
+	// Role is RoleUser, RoleModerator or RoleAdmin.
+	Role string `json:"role" db:"role"`
+
+	// IsBot is set for bot accounts, which have no email or password and only log in with an access token.
+	IsBot bool `json:"is_bot" db:"is_bot"`
+
+	// BotOwnerID is the user who created the bot, nil for human accounts.
+	BotOwnerID *int64 `json:"bot_owner_id,omitempty" db:"bot_owner_id"`
+
+	// LastSeenAt is when the user was last connected to the chat, nil if they never were.
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
+}
+
+// Profile is the part of a user that every logged in user may see.
+type Profile struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	Timezone    string `json:"timezone"`
+	Bot         bool   `json:"bot"`
+}
+
+// Profile returns the public part of the user.
+func (u User) Profile() Profile {
+	return Profile{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+		Timezone:    u.Timezone,
+		Bot:         u.IsBot,
+	}
 }
 
 // Repository is an interface that represents a thing that can do different things to the `users` table.
 type Repository interface {
 	// CreateUser takes a new user and a special tag that says what computer is doing the command.
 	// It then sends a command to the database to add the new user.
+	// It returns ErrEmailTaken or ErrUsernameTaken when another account already uses them.
 	CreateUser(contextTag context.Context, newUser *User) (*User, error)
+	// GetUserByEmail returns the user with the given email, ignoring case, or ErrUserNotFound.
 	GetUserByEmail(ctx context.Context, email string) (User, error)
+	// GetUserByID returns the user with the given ID, or ErrUserNotFound.
+	GetUserByID(ctx context.Context, id int64) (User, error)
+
+	// CreateRefreshToken stores a new refresh token (only its hash) for a user.
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error)
+	// GetRefreshToken looks up a refresh token by the hash of its value.
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	// UseRefreshToken marks a refresh token as used. It reports false if the token was already used or revoked.
+	UseRefreshToken(ctx context.Context, id int64) (bool, error)
+	// RevokeRefreshTokenFamily revokes every refresh token descending from the same login.
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+
+	// UpdatePassword replaces the password hash of a user.
+	UpdatePassword(ctx context.Context, userID int64, passwordHash string) error
+	// RevokeSession records a revoked session and revokes its refresh token family.
+	RevokeSession(ctx context.Context, sessionID string, userID int64, reason string, expiresAt time.Time) error
+	// IsSessionRevoked reports whether a session has been revoked.
+	IsSessionRevoked(ctx context.Context, sessionID string) (bool, error)
+	// ListSessionIDs returns the sessions of a user that still have a usable refresh token.
+	ListSessionIDs(ctx context.Context, userID int64) ([]string, error)
+	// CreateSession stores the device details of a new login session.
+	CreateSession(ctx context.Context, session *Session) error
+	// TouchSession updates the last activity, IP and user agent of a session.
+	TouchSession(ctx context.Context, sessionID string, ip string, userAgent string) error
+	// ListSessions returns the sessions of a user that still have a usable refresh token, most recently active first.
+	ListSessions(ctx context.Context, userID int64) ([]Session, error)
+
+	// SetEmailVerified marks the email address of a user as verified.
+	SetEmailVerified(ctx context.Context, userID int64) error
+	// CreateUserToken stores a new single-use token (only its hash).
+	CreateUserToken(ctx context.Context, token *UserToken) (*UserToken, error)
+	// GetUserToken returns a valid token without using it, or returns sql.ErrNoRows.
+	GetUserToken(ctx context.Context, purpose string, tokenHash string) (UserToken, error)
+	// ConsumeUserToken marks a valid token as used and returns it, or returns sql.ErrNoRows.
+	ConsumeUserToken(ctx context.Context, purpose string, tokenHash string) (UserToken, error)
+	// DeleteUserTokens deletes every token of a user for the given purpose.
+	DeleteUserTokens(ctx context.Context, userID int64, purpose string) error
+
+	// SetTOTPSecret stores a TOTP secret that still needs to be confirmed.
+	SetTOTPSecret(ctx context.Context, userID int64, secret string) error
+	// ReplaceTOTPSecret replaces the stored TOTP secret without changing whether it is enabled.
+	ReplaceTOTPSecret(ctx context.Context, userID int64, secret string) error
+	// EnableTOTP enables two-factor authentication and replaces the recovery codes (only their hashes).
+	EnableTOTP(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error
+	// DisableTOTP disables two-factor authentication and deletes the secret and recovery codes.
+	DisableTOTP(ctx context.Context, userID int64) error
+	// UseTOTPStep records an accepted time step, it reports false on a replayed code.
+	UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error)
+	// UseRecoveryCode marks a recovery code as used, it reports false if the code is unknown or used.
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error)
+
+	// GetUserByIdentity returns the user linked to an external identity, or ErrUserNotFound.
+	GetUserByIdentity(ctx context.Context, issuer string, subject string) (User, error)
+	// CreateIdentity links an external identity to a user.
+	CreateIdentity(ctx context.Context, identity *Identity) error
+
+	// UpdateProfile stores the profile fields of a user and returns the updated user.
+	UpdateProfile(ctx context.Context, user *User) (User, error)
+	// UpdateEmail replaces the email address of a user and marks it as not verified, or returns ErrEmailTaken.
+	UpdateEmail(ctx context.Context, userID int64, email string) error
+
+	// ListIdentities returns the external identities linked to a user.
+	ListIdentities(ctx context.Context, userID int64) ([]Identity, error)
+	// ScheduleDeletion marks a user to be purged after the given time, byAdmin when an administrator deleted it.
+	ScheduleDeletion(ctx context.Context, userID int64, deleteAfter time.Time, byAdmin bool) error
+	// CancelDeletion keeps a user that was scheduled for deletion, unless an administrator deleted it.
+	CancelDeletion(ctx context.Context, userID int64) error
+	// SearchUsers returns up to limit users whose username or display name matches the query,
+	// best matches first, starting after the given cursor when it is not nil.
+	SearchUsers(ctx context.Context, query string, after *SearchCursor, limit int) ([]SearchResult, error)
+	// SetRole changes the role of a user, or returns ErrUserNotFound.
+	SetRole(ctx context.Context, userID int64, role string) error
+	// SetRoleByEmail changes the role of the user with the given email address, or returns ErrUserNotFound.
+	SetRoleByEmail(ctx context.Context, email string, role string) error
+	// CreateAccessToken stores a new personal access token (only its hash).
+	CreateAccessToken(ctx context.Context, token *AccessToken) (*AccessToken, error)
+	// ListAccessTokens returns the personal access tokens of a user, newest first.
+	ListAccessTokens(ctx context.Context, userID int64) ([]AccessToken, error)
+	// GetAccessToken looks up a personal access token by the hash of its value, or returns sql.ErrNoRows.
+	GetAccessToken(ctx context.Context, tokenHash string) (AccessToken, error)
+	// TouchAccessToken records that a personal access token was used now.
+	TouchAccessToken(ctx context.Context, id int64) error
+	// DeleteAccessToken deletes a personal access token of the user, or returns ErrAccessTokenNotFound.
+	DeleteAccessToken(ctx context.Context, userID int64, id int64) error
+	// CreateBot stores a new bot account owned by bot.BotOwnerID, or returns ErrUsernameTaken,
+	// or ErrTooManyBots when the owner already has maxBots bots.
+	CreateBot(ctx context.Context, bot *User, maxBots int) (User, error)
+	// ListBots returns the bots owned by a user, oldest first.
+	ListBots(ctx context.Context, ownerID int64) ([]User, error)
+	// GetBot returns a bot owned by the user, or ErrBotNotFound.
+	GetBot(ctx context.Context, ownerID int64, botID int64) (User, error)
+	// DeleteBot deletes a bot owned by the user with its access tokens, or returns ErrBotNotFound.
+	DeleteBot(ctx context.Context, ownerID int64, botID int64) error
+	// SetLastSeen records when a user was last connected to the chat.
+	SetLastSeen(ctx context.Context, userID int64, at time.Time) error
+	// ListBlocks returns the users blocked or muted by a user with the kind of relation, newest first.
+	ListBlocks(ctx context.Context, userID int64) ([]BlockRes, error)
+	// ListBlockedIDs returns the IDs of the users blocked, not only muted, by a user.
+	ListBlockedIDs(ctx context.Context, userID int64) ([]int64, error)
+	// SetBlock blocks or mutes a user, replacing the previous relation, or returns ErrUserNotFound.
+	SetBlock(ctx context.Context, userID int64, blockedID int64, kind string) error
+	// DeleteBlock removes a block or mute, or returns ErrBlockNotFound.
+	DeleteBlock(ctx context.Context, userID int64, blockedID int64) error
+	// GetContact returns the contact or pending friend request between two users, in either direction,
+	// or ErrContactNotFound.
+	GetContact(ctx context.Context, userID int64, otherID int64) (Contact, error)
+	// CreateContactRequest stores a pending friend request, or returns ErrContactRequestExists.
+	CreateContactRequest(ctx context.Context, requesterID int64, addresseeID int64) error
+	// AcceptContactRequest turns a pending friend request into a contact, or returns ErrContactRequestNotFound.
+	AcceptContactRequest(ctx context.Context, requesterID int64, addresseeID int64) error
+	// DeleteContactRequest deletes a pending friend request, or returns ErrContactRequestNotFound.
+	DeleteContactRequest(ctx context.Context, requesterID int64, addresseeID int64) error
+	// DeleteContact removes an accepted contact between two users, or returns ErrContactNotFound.
+	DeleteContact(ctx context.Context, userID int64, otherID int64) error
+	// DeleteContacts removes the contact or pending friend request between two users, if there is one.
+	DeleteContacts(ctx context.Context, userID int64, otherID int64) error
+	// ListContacts returns the accepted contacts of a user, most recent first.
+	ListContacts(ctx context.Context, userID int64) ([]ContactRes, error)
+	// ListContactRequests returns the pending friend requests received by a user, or sent when incoming is false.
+	ListContactRequests(ctx context.Context, userID int64, incoming bool) ([]ContactRes, error)
+	// DeleteAccessTokens deletes every personal access token of a user.
+	DeleteAccessTokens(ctx context.Context, userID int64) error
+	// PurgeDeletedUsers deletes the users whose deletion is due at now, and their bots, with every row
+	// that references them, and returns the deleted users.
+	PurgeDeletedUsers(ctx context.Context, now time.Time) ([]User, error)
 }
 
 // Service is an interface that represents a thing that can do different things to the `users` table.
@@ -36,18 +337,132 @@ type Service interface {
 	// It then sends a command to the database to add the new user.
 	CreateUser(ctx context.Context, user *CreateUserReq) (*CreateUserRes, error)
 	LoginUser(c context.Context, req *LoginUserReq) (LoginUserRes, error)
+	// ParseToken validates a token issued by LoginUser and returns its claims.
+	ParseToken(c context.Context, token string) (*MyJWTClaims, error)
+	// RefreshToken rotates a refresh token and issues a new access token for the same session.
+	RefreshToken(c context.Context, refreshToken string) (LoginUserRes, error)
+	// Logout revokes the session identified by the given access or refresh token.
+	Logout(c context.Context, accessToken string, refreshToken string) error
+	// ChangePassword replaces the password of the user and revokes all their other sessions.
+	ChangePassword(c context.Context, claims *MyJWTClaims, req *ChangePasswordReq) error
+	// RevokeUserSessions revokes every session of a user, e.g. on an administrator's request.
+	RevokeUserSessions(c context.Context, userID int64, reason string) error
+	// JWKS returns the public keys that verify session tokens.
+	JWKS() JWKS
+	// VerifyEmail marks the email address of the token's user as verified.
+	VerifyEmail(c context.Context, token string) error
+	// ResendVerification sends a new verification email if the address belongs to an unverified account.
+	ResendVerification(c context.Context, email string) error
+	// ForgotPassword mails a password reset link if the address belongs to an account.
+	ForgotPassword(c context.Context, email string) error
+	// ResetPassword sets a new password using the token from a password reset link.
+	ResetPassword(c context.Context, req *ResetPasswordReq) error
+	// RequestMagicLink mails a single-use login link if the address belongs to an account.
+	RequestMagicLink(c context.Context, email string) error
+	// LoginMagicLink redeems a login link and starts a session, or a two-factor challenge when it is enabled.
+	LoginMagicLink(c context.Context, token string) (LoginUserRes, error)
+	// LoginTwoFactor completes a login started by LoginUser for an account with two-factor authentication.
+	LoginTwoFactor(c context.Context, req *LoginTwoFactorReq) (LoginUserRes, error)
+	// EnrollTOTP creates a new TOTP secret for the user, which must be confirmed with ConfirmTOTP.
+	EnrollTOTP(c context.Context, claims *MyJWTClaims) (*EnrollTOTPRes, error)
+	// ConfirmTOTP enables two-factor authentication and returns the recovery codes.
+	ConfirmTOTP(c context.Context, claims *MyJWTClaims, req *ConfirmTOTPReq) (*ConfirmTOTPRes, error)
+	// DisableTOTP disables two-factor authentication after checking the password and a code.
+	DisableTOTP(c context.Context, claims *MyJWTClaims, req *DisableTOTPReq) error
+	// OIDCLogin starts an OpenID Connect login and returns where to send the browser.
+	OIDCLogin(c context.Context) (*OIDCLoginRes, error)
+	// OIDCCallback finishes an OpenID Connect login and starts a session.
+	OIDCCallback(c context.Context, req *OIDCCallbackReq) (LoginUserRes, error)
+	// GetMe returns the account of the logged in user.
+	GetMe(c context.Context, claims *MyJWTClaims) (*MeRes, error)
+	// GetProfile returns the public profile of a user.
+	GetProfile(c context.Context, userID int64) (*Profile, error)
+	// UpdateProfile changes the profile fields present in the request.
+	UpdateProfile(c context.Context, claims *MyJWTClaims, req *UpdateProfileReq) (*MeRes, error)
+	// ChangeEmail replaces the email address after re-authentication and sends a new verification link.
+	ChangeEmail(c context.Context, claims *MyJWTClaims, req *ChangeEmailReq) error
+	// DeleteAccount schedules the account for deletion after re-authentication and logs out every session.
+	DeleteAccount(c context.Context, claims *MyJWTClaims, req *DeleteAccountReq) (*DeleteAccountRes, error)
+	// ExportAccount collects the personal data of the logged in user.
+	ExportAccount(c context.Context, claims *MyJWTClaims) (*AccountExport, error)
+	// SearchUsers looks users up by username or display name, one page at a time.
+	SearchUsers(c context.Context, req *SearchUsersReq) (*SearchUsersRes, error)
+	// ListAccessTokens returns the personal access tokens of the logged in user, without their values.
+	ListAccessTokens(c context.Context, claims *MyJWTClaims) ([]AccessToken, error)
+	// CreateAccessToken creates a personal access token; its value is only returned here.
+	CreateAccessToken(c context.Context, claims *MyJWTClaims, req *CreateAccessTokenReq) (*CreateAccessTokenRes, error)
+	// DeleteAccessToken revokes a personal access token of the logged in user.
+	DeleteAccessToken(c context.Context, claims *MyJWTClaims, id int64) error
+	// ListBots returns the bots owned by the logged in user.
+	ListBots(c context.Context, claims *MyJWTClaims) ([]Profile, error)
+	// CreateBot creates a bot owned by the logged in user and returns its first access token.
+	CreateBot(c context.Context, claims *MyJWTClaims, req *CreateBotReq) (*BotTokenRes, error)
+	// ResetBotToken replaces the access tokens of a bot with a new one and closes its connections.
+	ResetBotToken(c context.Context, claims *MyJWTClaims, botID int64) (*BotTokenRes, error)
+	// DeleteBot deletes a bot owned by the logged in user and closes its connections.
+	DeleteBot(c context.Context, claims *MyJWTClaims, botID int64) error
+	// GetPresence tells whether a user is online, away or offline, and when they were last seen.
+	GetPresence(c context.Context, userID int64) (*PresenceRes, error)
+	// RecordLastSeen stores when a user was last connected to the chat.
+	RecordLastSeen(c context.Context, userID int64, at time.Time) error
+	// ListBlocks returns the users blocked or muted by the logged in user.
+	ListBlocks(c context.Context, claims *MyJWTClaims) ([]BlockRes, error)
+	// SetBlock blocks or mutes another user.
+	SetBlock(c context.Context, claims *MyJWTClaims, userID int64, req *SetBlockReq) error
+	// DeleteBlock unblocks or unmutes another user.
+	DeleteBlock(c context.Context, claims *MyJWTClaims, userID int64) error
+	// BlockedUserIDs returns the IDs of the users blocked by a user, for the chat to filter their messages.
+	BlockedUserIDs(c context.Context, userID string) ([]string, error)
+	// ListSessions returns the login sessions of the logged in user with their devices and open WebSockets.
+	ListSessions(c context.Context, claims *MyJWTClaims) ([]Session, error)
+	// DeleteSession logs out one session of the logged in user and closes its WebSockets.
+	DeleteSession(c context.Context, claims *MyJWTClaims, sessionID string) error
+	// DeleteAllSessions logs out every session of the logged in user, the current one included.
+	DeleteAllSessions(c context.Context, claims *MyJWTClaims) error
+	// ListContacts returns the contacts of the logged in user.
+	ListContacts(c context.Context, claims *MyJWTClaims) ([]ContactRes, error)
+	// ListContactRequests returns the pending friend requests received and sent by the logged in user.
+	ListContactRequests(c context.Context, claims *MyJWTClaims) (*ContactRequestsRes, error)
+	// SendContactRequest sends a friend request, or accepts the one the other user already sent.
+	SendContactRequest(c context.Context, claims *MyJWTClaims, req *SendContactRequestReq) (*SendContactRequestRes, error)
+	// AcceptContactRequest accepts a friend request received from another user.
+	AcceptContactRequest(c context.Context, claims *MyJWTClaims, requesterID int64) error
+	// DeclineContactRequest declines a friend request received from another user, without telling them.
+	DeclineContactRequest(c context.Context, claims *MyJWTClaims, requesterID int64) error
+	// CancelContactRequest withdraws a friend request sent to another user.
+	CancelContactRequest(c context.Context, claims *MyJWTClaims, addresseeID int64) error
+	// DeleteContact removes another user from the contacts, on both sides.
+	DeleteContact(c context.Context, claims *MyJWTClaims, userID int64) error
+	// BootstrapAdmins gives the admin role to the accounts with the given email addresses.
+	BootstrapAdmins(c context.Context, emails []string) error
+	// GetUser returns the account details of any user, for administrators.
+	GetUser(c context.Context, userID int64) (*AdminUserRes, error)
+	// SetRole changes the role of another user and logs out their sessions so the new role applies at once.
+	SetRole(c context.Context, claims *MyJWTClaims, userID int64, req *SetRoleReq) (*AdminUserRes, error)
+	// DeleteUser schedules the deletion of another user's account, as if they had deleted it themselves.
+	DeleteUser(c context.Context, claims *MyJWTClaims, userID int64) (*DeleteAccountRes, error)
+	// ListLockouts lists the login throttle keys that are currently locked.
+	ListLockouts(c context.Context) ([]throttle.Entry, error)
+	// Unlock lifts the lockout of a login throttle key.
+	Unlock(c context.Context, key string) error
+	// ListAuditEvents returns a page of the authentication audit log, newest first.
+	ListAuditEvents(c context.Context, req *ListAuditEventsReq) (*AuditEventsRes, error)
+	// ExportAuditEvents writes the matching audit events to w as JSON Lines.
+	ExportAuditEvents(c context.Context, req *ListAuditEventsReq, w io.Writer) error
+	// PurgeDeletedAccounts deletes the accounts whose grace period is over and returns how many there were.
+	PurgeDeletedAccounts(c context.Context) (int, error)
 }
 
 // CreateUserReq is a struct that represents a request to create a new user.
 type CreateUserReq struct {
 	// Username is the user's chosen name to login.
-	Username string `json:"username" db:"username"`
+	Username string `json:"username" db:"username" binding:"required,username"`
 
 	// Password is the user's chosen password.
-	Password string `json:"password" db:"password"`
+	Password string `json:"password" db:"password" binding:"required,max=1024"`
 
 	// Email is the user's email address.
-	Email string `json:"email" db:"email"`
+	Email string `json:"email" db:"email" binding:"required,email_address"`
 }
 
 // CreateUserRes is a struct that represents a response to a request to create a new user.
@@ -63,18 +478,384 @@ type CreateUserRes struct {
 }
 
 type LoginUserReq struct {
-	Email    string `json:"email" db:"email"`
-	Password string `json:"password" db:"password"`
+	Email    string `json:"email" db:"email" binding:"required,email_address"`
+	Password string `json:"password" db:"password" binding:"required,max=1024"`
 }
 
+// LoginUserRes is the result of a login step.
+// When TwoFactorRequired is set no session was created yet: Challenge must be sent to /login/2fa with a code.
 type LoginUserRes struct {
-	access_token string
-	ID           string `json:"id" db:"id"`
-	Username     string `json:"username" db:"username"`
+	access_token      string
+	access_ttl        time.Duration
+	refresh_token     string
+	refresh_ttl       time.Duration
+	redirect_to       string
+	ID                string `json:"id,omitempty" db:"id"`
+	Username          string `json:"username,omitempty" db:"username"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	Challenge         string `json:"challenge,omitempty"`
+}
+
+// LoginTwoFactorReq is the second login step: the challenge from LoginUser and either
+// a code from the authenticator app or one of the recovery codes.
+type LoginTwoFactorReq struct {
+	Challenge    string `json:"challenge" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery_code" binding:"max=64"`
+}
+
+// EnrollTOTPRes carries a new TOTP secret and the otpauth:// URI to show as a QR code.
+type EnrollTOTPRes struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// ConfirmTOTPReq is a struct that represents the code that confirms a TOTP enrolment.
+type ConfirmTOTPReq struct {
+	Code string `json:"code" binding:"required,numeric,len=6"`
+}
+
+// ConfirmTOTPRes carries the recovery codes. They are only shown once and stored hashed.
+type ConfirmTOTPRes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableTOTPReq is a struct that represents a request to turn two-factor authentication off.
+type DisableTOTPReq struct {
+	Password     string `json:"password" binding:"required,max=1024"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery_code" binding:"max=64"`
+}
+
+// VerifyEmailReq is a struct that represents a request to verify an email address.
+type VerifyEmailReq struct {
+	Token string `json:"token" form:"token" binding:"required,max=256"`
+}
+
+// ResendVerificationReq is a struct that represents a request for a new verification email.
+type ResendVerificationReq struct {
+	Email string `json:"email" binding:"required,email_address"`
+}
+
+// MagicLinkReq is a struct that represents a request for a passwordless login link.
+type MagicLinkReq struct {
+	Email string `json:"email" binding:"required,email_address"`
 }
 
+// LoginMagicLinkReq is the token of a login link, given as a "token" form field, query parameter or JSON body.
+// CSRF is the token of the page that posted the form, JSON bodies do not need one.
+type LoginMagicLinkReq struct {
+	Token string `json:"token" form:"token" binding:"required,max=256"`
+	CSRF  string `json:"-" form:"csrf" binding:"max=256"`
+}
+
+// ForgotPasswordReq is a struct that represents a request for a password reset link.
+type ForgotPasswordReq struct {
+	Email string `json:"email" binding:"required,email_address"`
+}
+
+// PasswordResetPageReq is the token of a password reset link, given as a "token" query parameter.
+type PasswordResetPageReq struct {
+	Token string `form:"token" binding:"required,max=256"`
+}
+
+// ResetPasswordReq is a struct that represents a request to set a new password with a reset token,
+// posted by PasswordResetPage or in a JSON body. CSRF is the token of the page that posted the form.
+type ResetPasswordReq struct {
+	Token    string `json:"token" form:"token" binding:"required,max=256"`
+	Password string `json:"password" form:"password" binding:"required,max=1024"`
+	CSRF     string `json:"-" form:"csrf" binding:"max=256"`
+}
+
+// ChangePasswordReq is a struct that represents a request to change the password of the logged in user.
+// Code or RecoveryCode is required when the account uses two-factor authentication.
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"current_password" binding:"required,max=1024"`
+	NewPassword     string `json:"new_password" binding:"required,max=1024"`
+	Code            string `json:"code" binding:"omitempty,numeric,len=6"`
+	RecoveryCode    string `json:"recovery_code" binding:"max=64"`
+}
+
+// ChangeEmailReq is a struct that represents a request to change the email address of the logged in user.
+// Code or RecoveryCode is required when the account uses two-factor authentication.
+type ChangeEmailReq struct {
+	CurrentPassword string `json:"current_password" binding:"required,max=1024"`
+	NewEmail        string `json:"new_email" binding:"required,email_address"`
+	Code            string `json:"code" binding:"omitempty,numeric,len=6"`
+	RecoveryCode    string `json:"recovery_code" binding:"max=64"`
+}
+
+// DeleteAccountReq is a struct that represents a request to delete the account of the logged in user.
+// Code or RecoveryCode is required when the account uses two-factor authentication.
+type DeleteAccountReq struct {
+	CurrentPassword string `json:"current_password" binding:"required,max=1024"`
+	Code            string `json:"code" binding:"omitempty,numeric,len=6"`
+	RecoveryCode    string `json:"recovery_code" binding:"max=64"`
+}
+
+// DeleteAccountRes tells until when the deletion can be cancelled by logging in again.
+type DeleteAccountRes struct {
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+// ExportAccountReq selects the format of a personal data export, "zip" by default.
+type ExportAccountReq struct {
+	Format string `form:"format" binding:"omitempty,oneof=zip json"`
+}
+
+// SearchUsersReq is a page of a user search. Cursor is the NextCursor of the previous page.
+type SearchUsersReq struct {
+	Query  string `form:"q" binding:"required,max=64"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=50"`
+	Cursor string `form:"cursor" binding:"max=128"`
+}
+
+// SearchUsersRes is a page of search results. NextCursor is empty on the last page.
+type SearchUsersRes struct {
+	Users      []Profile `json:"users"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// SearchCursor is the position of a search result: its relevance score and the user ID breaking ties.
+type SearchCursor struct {
+	Score string
+	ID    int64
+}
+
+// SearchResult is a user found by a search and its position in the results.
+type SearchResult struct {
+	User   User
+	Cursor SearchCursor
+}
+
+// UpdateProfileReq is a partial profile update, fields left out of the JSON are not changed.
+// The format of AvatarURL and Timezone is checked by the service, where an empty value resets them.
+type UpdateProfileReq struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=64"`
+	Bio         *string `json:"bio" binding:"omitempty,max=500"`
+	AvatarURL   *string `json:"avatar_url" binding:"omitempty,max=2048"`
+	Timezone    *string `json:"timezone" binding:"omitempty,max=64"`
+}
+
+// MeRes is the account of the logged in user: the public profile and the private account settings.
+type MeRes struct {
+	Profile
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	TOTPEnabled   bool       `json:"totp_enabled"`
+	Role          string     `json:"role"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeleteAfter   *time.Time `json:"delete_after,omitempty"`
+}
+
+// CreateBotReq is a struct that represents a request to create a bot account.
+type CreateBotReq struct {
+	Username    string `json:"username" binding:"required,username"`
+	DisplayName string `json:"display_name" binding:"omitempty,max=64"`
+}
+
+// BotTokenRes carries a bot and the value of its access token, which is only shown once.
+type BotTokenRes struct {
+	Bot       Profile   `json:"bot"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PresenceRes is the presence of a user. LastSeenAt is when they last went offline or came online.
+type PresenceRes struct {
+	UserID     int64      `json:"user_id"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+// SetBlockReq is a struct that represents a request to block or mute a user.
+type SetBlockReq struct {
+	Kind string `json:"kind" binding:"required,oneof=block mute"`
+}
+
+// BlockRes is a user blocked or muted by the logged in user.
+type BlockRes struct {
+	User      Profile   `json:"user"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Contact is a row of the contacts table: a friend request from RequesterID to AddresseeID,
+// which makes them contacts of each other once accepted.
+type Contact struct {
+	RequesterID int64      `json:"requester_id" db:"requester_id"`
+	AddresseeID int64      `json:"addressee_id" db:"addressee_id"`
+	Status      string     `json:"status" db:"status"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	AcceptedAt  *time.Time `json:"accepted_at" db:"accepted_at"`
+}
+
+// ContactRes is a contact or a friend request with the other user's profile.
+// Since is when the request was accepted, or sent while it is pending.
+type ContactRes struct {
+	User  Profile   `json:"user"`
+	Since time.Time `json:"since"`
+}
+
+// ContactRequestsRes lists the pending friend requests of the logged in user.
+type ContactRequestsRes struct {
+	Incoming []ContactRes `json:"incoming"`
+	Outgoing []ContactRes `json:"outgoing"`
+}
+
+// SendContactRequestReq is a struct that represents a friend request to another user.
+type SendContactRequestReq struct {
+	UserID int64 `json:"user_id" binding:"required,min=1"`
+}
+
+// SendContactRequestRes tells whether the request is pending, or accepted at once because the other user
+// had already sent one.
+type SendContactRequestRes struct {
+	Status string `json:"status"`
+}
+
+// ListAuditEventsReq filters the audit log. Since and Until are RFC 3339 times, Cursor is the NextCursor
+// of the previous page. Every filter is optional.
+type ListAuditEventsReq struct {
+	Type    string    `form:"type" binding:"omitempty,oneof=register login magic_link_requested logout password_change password_reset access_token_created access_token_deleted bot_token_created"`
+	UserID  int64     `form:"user_id" binding:"omitempty,min=1"`
+	Email   string    `form:"email" binding:"max=254"`
+	IP      string    `form:"ip" binding:"omitempty,ip"`
+	Success *bool     `form:"success"`
+	Since   time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until   time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit   int       `form:"limit" binding:"omitempty,min=1,max=200"`
+	Cursor  string    `form:"cursor" binding:"max=20"`
+}
+
+// AuditEventsRes is a page of the audit log. NextCursor is empty on the last page.
+type AuditEventsRes struct {
+	Events     []audit.Event `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// AdminUserRes is a user as seen by administrators.
+type AdminUserRes struct {
+	MeRes
+}
+
+// SetRoleReq is a struct that represents a request to change the role of a user.
+type SetRoleReq struct {
+	Role string `json:"role" binding:"required,oneof=user moderator admin"`
+}
+
+// MyJWTClaims are the claims of an access token.
+// RegisteredClaims.ID carries the unique token ID (jti), SessionID the login session (sid)
+// shared by every access token refreshed from the same login.
 type MyJWTClaims struct {
-	ID       string `json:"id" db:"id"`
-	Username string `json:"username" db:"username"`
+	ID            string `json:"id" db:"id"`
+	Username      string `json:"username" db:"username"`
+	SessionID     string `json:"sid"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          string `json:"role"`
+
+	// Scopes limits what a personal access token may do. Tokens from a login have none and are not limited.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Bot is set when the token belongs to a bot account.
+	Bot bool `json:"bot,omitempty"`
+
 	jwt.RegisteredClaims
 }
+
+// IsAccessToken reports whether the claims come from a personal access token rather than a login session.
+func (c *MyJWTClaims) IsAccessToken() bool {
+	return strings.HasPrefix(c.SessionID, accessTokenSessionPrefix)
+}
+
+// HasScope reports whether the claims allow scope. Login sessions allow every scope.
+func (c *MyJWTClaims) HasScope(scope string) bool {
+	return !c.IsAccessToken() || contains(c.Scopes, scope)
+}
+
+// AccessToken is a personal access token used by scripts instead of a login.
+type AccessToken struct {
+	ID         int64      `json:"id" db:"id"`
+	UserID     int64      `json:"-" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	TokenHash  string     `json:"-" db:"token_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+}
+
+// CreateAccessTokenReq is a struct that represents a request to create a personal access token.
+// The token expires after ExpiresInDays, 90 days when left out.
+type CreateAccessTokenReq struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=profile:read profile:write users:read rooms:read rooms:write messages:write"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+// CreateAccessTokenRes is a new personal access token. Token is only shown once.
+type CreateAccessTokenRes struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+// RefreshToken is an opaque, single-use token that can be exchanged for a new access token.
+// Every rotation keeps the FamilyID of the login that started the chain.
+type RefreshToken struct {
+	ID        int64      `json:"id" db:"id"`
+	UserID    int64      `json:"user_id" db:"user_id"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at" db:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at" db:"revoked_at"`
+}
+
+// Session is a login session as shown to its user: the device that logged in, when it was last active and
+// how many WebSockets it has open on this server node. Current marks the session making the request.
+type Session struct {
+	ID           string    `json:"id" db:"id"`
+	UserID       int64     `json:"-" db:"user_id"`
+	IP           string    `json:"ip" db:"ip"`
+	UserAgent    string    `json:"user_agent" db:"user_agent"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at" db:"last_active_at"`
+	Connections  int       `json:"connections" db:"-"`
+	Current      bool      `json:"current" db:"-"`
+}
+
+// UserToken is a single-use token sent to a user by email, such as an email verification link.
+type UserToken struct {
+	ID        int64      `json:"id" db:"id"`
+	UserID    int64      `json:"user_id" db:"user_id"`
+	Purpose   string     `json:"purpose" db:"purpose"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at" db:"used_at"`
+}
+
+// Identity links a user to the subject of an external OpenID Connect identity provider.
+type Identity struct {
+	ID        int64     `json:"id" db:"id"`
+	UserID    int64     `json:"user_id" db:"user_id"`
+	Issuer    string    `json:"issuer" db:"issuer"`
+	Subject   string    `json:"subject" db:"subject"`
+	Email     string    `json:"email" db:"email"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// OIDCLoginRes is the start of an OpenID Connect login.
+// The browser is redirected to URL, State is kept in a cookie until the callback.
+type OIDCLoginRes struct {
+	URL   string
+	State string
+}
+
+// OIDCCallbackReq is the redirect back from the identity provider.
+type OIDCCallbackReq struct {
+	Code        string `form:"code" binding:"required"`
+	State       string `form:"state" binding:"required"`
+	StoredState string `form:"-"`
+}
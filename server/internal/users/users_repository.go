@@ -3,6 +3,11 @@ package users
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 // DBTX is an interface that defines a set of methods for executing SQL queries and transactions.
@@ -11,6 +16,9 @@ type DBTX interface {
 	// Exec executes a query without returning any rows.
 	// It is typically used for insert, update, or delete operations.
 	Exec(query string, args ...interface{}) (sql.Result, error)
+	// ExecContext is similar to Exec, but takes a context.Context as its first argument.
+	// It is used to cancel the statement if the context is canceled.
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 	// Query executes a query that returns rows, and returns a *sql.Rows result.
 	// It can be used to retrieve data from the database.
 	Query(query string, args ...interface{}) (*sql.Rows, error)
@@ -62,8 +70,8 @@ func (r *repository) CreateUser(ctx context.Context, user *User) (*User, error)
 	err := r.db.QueryRowContext(ctx, query, user.Username, user.Password, user.Email).Scan(&lastInsertId)
 
 	if err != nil {
-		// If there is an error, it is returned to the caller.
-		return nil, err
+		// If there is an error, it is returned to the caller as a domain error when it has a meaning, e.g. ErrEmailTaken.
+		return nil, mapUserError(err)
 	}
 
 	// The ID of the inserted user is set to the User struct.
@@ -72,14 +80,912 @@ func (r *repository) CreateUser(ctx context.Context, user *User) (*User, error)
 	return user, nil
 }
 
+// GetUserByEmail returns the user with the given email address. The comparison ignores case,
+// like the users_email_key index. Bots have no email address and are never returned.
 func (r *repository) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE lower(email) = lower($1) AND NOT is_bot"
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email))
+	return user, mapUserError(err)
+}
+
+// GetUserByID returns the user with the given ID.
+func (r *repository) GetUserByID(ctx context.Context, id int64) (User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1"
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
+	return user, mapUserError(err)
+}
+
+// userColumns lists the columns of the users table in the order scanUser reads them.
+const userColumns = "id, username, email, password, email_verified, totp_secret, totp_enabled, totp_last_step, " +
+	"display_name, bio, avatar_url, timezone, updated_at, delete_after, role, is_bot, bot_owner_id, last_seen_at, deleted_by_admin"
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a row selected with userColumns, followed by the columns read into extra.
+func scanUser(row scanner, extra ...interface{}) (User, error) {
 	user := User{}
-	query := "SELECT * FROM users WHERE email = $1"
 
-	err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Username, &user.Password)
+	dest := append([]interface{}{&user.ID, &user.Username, &user.Email, &user.Password, &user.EmailVerified,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep,
+		&user.DisplayName, &user.Bio, &user.AvatarURL, &user.Timezone, &user.UpdatedAt, &user.DeleteAfter, &user.Role,
+		&user.IsBot, &user.BotOwnerID, &user.LastSeenAt, &user.DeletedByAdmin}, extra...)
+	err := row.Scan(dest...)
 	if err != nil {
 		return User{}, err
 	}
 
 	return user, nil
 }
+
+// CreateRefreshToken inserts a new refresh token and returns it with its generated ID.
+func (r *repository) CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error) {
+	query := "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4) returning id, created_at"
+
+	err := r.db.QueryRowContext(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetRefreshToken returns the refresh token whose hash matches tokenHash.
+func (r *repository) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	token := RefreshToken{}
+	query := "SELECT id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = $1"
+
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt, &token.RevokedAt)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+// UseRefreshToken atomically marks the token as used.
+// It returns false when another request already used the token or it has been revoked,
+// which lets the caller detect two concurrent rotations of the same token.
+func (r *repository) UseRefreshToken(ctx context.Context, id int64) (bool, error) {
+	query := "UPDATE refresh_tokens SET used_at = now() WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL"
+
+	res, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token sharing familyID that is not revoked yet.
+func (r *repository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := "UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL"
+
+	_, err := r.db.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// UpdatePassword replaces the password hash of the given user.
+func (r *repository) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	query := "UPDATE users SET password = $1 WHERE id = $2"
+
+	_, err := r.db.ExecContext(ctx, query, passwordHash, userID)
+	return err
+}
+
+// RevokeSession inserts the session into revoked_sessions, revokes its refresh token family and deletes
+// its row from sessions in a single transaction.
+func (r *repository) RevokeSession(ctx context.Context, sessionID string, userID int64, reason string, expiresAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO revoked_sessions (session_id, user_id, reason, expires_at) VALUES ($1, $2, $3, $4) ON CONFLICT (session_id) DO NOTHING"
+	if _, err := tx.ExecContext(ctx, query, sessionID, userID, reason, expiresAt); err != nil {
+		return err
+	}
+
+	query = "UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL"
+	if _, err := tx.ExecContext(ctx, query, sessionID); err != nil {
+		return err
+	}
+
+	query = "DELETE FROM sessions WHERE id = $1"
+	if _, err := tx.ExecContext(ctx, query, sessionID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsSessionRevoked reports whether the session is listed in revoked_sessions.
+func (r *repository) IsSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	var revoked bool
+	query := "SELECT EXISTS (SELECT 1 FROM revoked_sessions WHERE session_id = $1)"
+
+	err := r.db.QueryRowContext(ctx, query, sessionID).Scan(&revoked)
+	return revoked, err
+}
+
+// ListSessionIDs returns the refresh token families of a user that are neither revoked nor expired.
+func (r *repository) ListSessionIDs(ctx context.Context, userID int64) ([]string, error) {
+	query := "SELECT DISTINCT family_id FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()"
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CreateSession inserts the row describing a new login session.
+func (r *repository) CreateSession(ctx context.Context, session *Session) error {
+	query := "INSERT INTO sessions (id, user_id, ip, user_agent) VALUES ($1, $2, $3, $4) returning created_at, last_active_at"
+
+	return r.db.QueryRowContext(ctx, query, session.ID, session.UserID, session.IP, session.UserAgent).
+		Scan(&session.CreatedAt, &session.LastActiveAt)
+}
+
+// TouchSession sets the last activity of a session to now, and its IP and user agent to the latest ones.
+func (r *repository) TouchSession(ctx context.Context, sessionID string, ip string, userAgent string) error {
+	query := "UPDATE sessions SET last_active_at = now(), ip = $2, user_agent = $3 WHERE id = $1"
+
+	_, err := r.db.ExecContext(ctx, query, sessionID, ip, userAgent)
+	return err
+}
+
+// ListSessions returns the sessions of a user whose refresh token family is neither revoked nor expired.
+// Sessions started before the sessions table existed have no row and are left out.
+func (r *repository) ListSessions(ctx context.Context, userID int64) ([]Session, error) {
+	query := "SELECT id, user_id, ip, user_agent, created_at, last_active_at FROM sessions " +
+		"WHERE user_id = $1 AND id IN (SELECT family_id FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()) " +
+		"ORDER BY last_active_at DESC"
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastActiveAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// SetEmailVerified marks the email address of the user as verified.
+func (r *repository) SetEmailVerified(ctx context.Context, userID int64) error {
+	query := "UPDATE users SET email_verified = true WHERE id = $1"
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// CreateUserToken inserts a new single-use token.
+func (r *repository) CreateUserToken(ctx context.Context, token *UserToken) (*UserToken, error) {
+	query := "INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4) returning id, created_at"
+
+	err := r.db.QueryRowContext(ctx, query, token.UserID, token.Purpose, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetUserToken returns an unused, unexpired token without marking it as used.
+// It returns sql.ErrNoRows when no such token exists.
+func (r *repository) GetUserToken(ctx context.Context, purpose string, tokenHash string) (UserToken, error) {
+	token := UserToken{}
+	query := "SELECT id, user_id, purpose, token_hash, expires_at, created_at, used_at FROM user_tokens " +
+		"WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()"
+
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).Scan(&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt)
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	return token, nil
+}
+
+// ConsumeUserToken atomically marks an unused, unexpired token as used and returns it.
+// It returns sql.ErrNoRows when no such token exists.
+func (r *repository) ConsumeUserToken(ctx context.Context, purpose string, tokenHash string) (UserToken, error) {
+	token := UserToken{}
+	query := "UPDATE user_tokens SET used_at = now() WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now() " +
+		"returning id, user_id, purpose, token_hash, expires_at, created_at, used_at"
+
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).Scan(&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt)
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	return token, nil
+}
+
+// DeleteUserTokens deletes every token of the user created for the given purpose.
+func (r *repository) DeleteUserTokens(ctx context.Context, userID int64, purpose string) error {
+	query := "DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2"
+
+	_, err := r.db.ExecContext(ctx, query, userID, purpose)
+	return err
+}
+
+// SetTOTPSecret stores a TOTP secret that is not enabled yet.
+func (r *repository) SetTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	query := "UPDATE users SET totp_secret = $1, totp_enabled = false, totp_last_step = 0 WHERE id = $2"
+
+	_, err := r.db.ExecContext(ctx, query, secret, userID)
+	return err
+}
+
+// ReplaceTOTPSecret replaces the TOTP secret of the user, enabled or not.
+func (r *repository) ReplaceTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	query := "UPDATE users SET totp_secret = $1 WHERE id = $2"
+
+	_, err := r.db.ExecContext(ctx, query, secret, userID)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication and replaces the recovery codes of the user.
+func (r *repository) EnableTOTP(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE users SET totp_enabled = true, totp_last_step = $1 WHERE id = $2"
+	if _, err := tx.ExecContext(ctx, query, step, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		query = "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)"
+		if _, err := tx.ExecContext(ctx, query, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication and deletes the secret and recovery codes.
+func (r *repository) DisableTOTP(ctx context.Context, userID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE users SET totp_secret = '', totp_enabled = false, totp_last_step = 0 WHERE id = $1"
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code.
+// It returns false when a code of the same or a later step was already accepted, i.e. on a replay.
+func (r *repository) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	query := "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1"
+
+	res, err := r.db.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used. It returns false if there is none.
+func (r *repository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	query := "UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+
+	res, err := r.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetUserByIdentity returns the user linked to the subject of an external identity provider.
+func (r *repository) GetUserByIdentity(ctx context.Context, issuer string, subject string) (User, error) {
+	query := "SELECT " + prefixColumns("u", userColumns) + " FROM users u JOIN user_identities i ON i.user_id = u.id WHERE i.issuer = $1 AND i.subject = $2"
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, issuer, subject))
+	return user, mapUserError(err)
+}
+
+// ListIdentities returns the external identities linked to a user, oldest first.
+func (r *repository) ListIdentities(ctx context.Context, userID int64) ([]Identity, error) {
+	query := "SELECT id, user_id, issuer, subject, email, created_at FROM user_identities WHERE user_id = $1 ORDER BY created_at"
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// ScheduleDeletion sets the time after which the user is purged. A deletion by an administrator stays marked as such.
+func (r *repository) ScheduleDeletion(ctx context.Context, userID int64, deleteAfter time.Time, byAdmin bool) error {
+	query := "UPDATE users SET delete_after = $1, deleted_by_admin = deleted_by_admin OR $2 WHERE id = $3"
+
+	_, err := r.db.ExecContext(ctx, query, deleteAfter, byAdmin, userID)
+	return err
+}
+
+// CancelDeletion clears the scheduled deletion of the user, unless an administrator deleted it.
+func (r *repository) CancelDeletion(ctx context.Context, userID int64) error {
+	query := "UPDATE users SET delete_after = NULL WHERE id = $1 AND NOT deleted_by_admin"
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// SearchUsers matches the lowercase query as a prefix of the username or display name, or as similar to them
+// by trigram similarity. Prefix matches rank first, then the most similar names; ties are ordered by ID.
+// The score is rounded to a numeric so a cursor compares equal to the row it was taken from.
+// Users waiting for deletion are left out.
+func (r *repository) SearchUsers(ctx context.Context, query string, after *SearchCursor, limit int) ([]SearchResult, error) {
+	sqlQuery := "SELECT " + userColumns + ", score FROM (" +
+		"SELECT *, round((CASE WHEN lower(username) LIKE $1 OR lower(display_name) LIKE $1 THEN 1 ELSE 0 END + " +
+		"greatest(similarity(lower(username), $2), similarity(lower(display_name), $2)))::numeric, 6) AS score " +
+		"FROM users WHERE delete_after IS NULL AND (lower(username) LIKE $1 OR lower(display_name) LIKE $1 OR lower(username) % $2 OR lower(display_name) % $2)" +
+		") matches WHERE $3::numeric IS NULL OR score < $3 OR (score = $3 AND id > $4) " +
+		"ORDER BY score DESC, id ASC LIMIT $5"
+
+	var afterScore sql.NullString
+	var afterID int64
+	if after != nil {
+		afterScore = sql.NullString{String: after.Score, Valid: true}
+		afterID = after.ID
+	}
+
+	prefix := likeEscaper.Replace(query) + "%"
+	rows, err := r.db.QueryContext(ctx, sqlQuery, prefix, query, afterScore, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		res.User, err = scanUser(rows, &res.Cursor.Score)
+		if err != nil {
+			return nil, err
+		}
+		res.Cursor.ID = res.User.ID
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SetRole changes the role of the user.
+func (r *repository) SetRole(ctx context.Context, userID int64, role string) error {
+	query := "UPDATE users SET role = $1 WHERE id = $2"
+
+	res, err := r.db.ExecContext(ctx, query, role, userID)
+	return rowAffected(res, err)
+}
+
+// SetRoleByEmail changes the role of the user with the given email address, ignoring its case.
+func (r *repository) SetRoleByEmail(ctx context.Context, email string, role string) error {
+	query := "UPDATE users SET role = $1 WHERE lower(email) = lower($2) AND NOT is_bot"
+
+	res, err := r.db.ExecContext(ctx, query, role, email)
+	return rowAffected(res, err)
+}
+
+// rowAffected turns an update that changed no row into ErrUserNotFound.
+func rowAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// accessTokenColumns lists the columns of the personal_access_tokens table in the order scanAccessToken reads them.
+const accessTokenColumns = "id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at"
+
+// scanAccessToken reads a row selected with accessTokenColumns.
+func scanAccessToken(row scanner) (AccessToken, error) {
+	token := AccessToken{}
+
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, pq.Array(&token.Scopes),
+		&token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	return token, nil
+}
+
+// CreateAccessToken stores a new personal access token.
+func (r *repository) CreateAccessToken(ctx context.Context, token *AccessToken) (*AccessToken, error) {
+	query := "INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5) returning id, created_at"
+
+	err := r.db.QueryRowContext(ctx, query, token.UserID, token.Name, token.TokenHash, pq.Array(token.Scopes), token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// ListAccessTokens returns the personal access tokens of the user, newest first.
+func (r *repository) ListAccessTokens(ctx context.Context, userID int64) ([]AccessToken, error) {
+	query := "SELECT " + accessTokenColumns + " FROM personal_access_tokens WHERE user_id = $1 ORDER BY created_at DESC"
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// GetAccessToken looks up a personal access token by the hash of its value, expired or not.
+func (r *repository) GetAccessToken(ctx context.Context, tokenHash string) (AccessToken, error) {
+	query := "SELECT " + accessTokenColumns + " FROM personal_access_tokens WHERE token_hash = $1"
+
+	return scanAccessToken(r.db.QueryRowContext(ctx, query, tokenHash))
+}
+
+// TouchAccessToken sets the last use of a personal access token to now.
+func (r *repository) TouchAccessToken(ctx context.Context, id int64) error {
+	query := "UPDATE personal_access_tokens SET last_used_at = now() WHERE id = $1"
+
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// DeleteAccessToken deletes a personal access token, only if it belongs to the user.
+func (r *repository) DeleteAccessToken(ctx context.Context, userID int64, id int64) error {
+	query := "DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2"
+
+	res, err := r.db.ExecContext(ctx, query, id, userID)
+	err = rowAffected(res, err)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrAccessTokenNotFound
+	}
+	return err
+}
+
+// SetLastSeen sets the last time the user was connected to the chat.
+func (r *repository) SetLastSeen(ctx context.Context, userID int64, at time.Time) error {
+	query := "UPDATE users SET last_seen_at = $1 WHERE id = $2"
+
+	_, err := r.db.ExecContext(ctx, query, at, userID)
+	return err
+}
+
+// ListBlocks returns the users blocked or muted by the user, newest first.
+func (r *repository) ListBlocks(ctx context.Context, userID int64) ([]BlockRes, error) {
+	query := "SELECT " + prefixColumns("u", userColumns) + ", b.kind, b.created_at FROM user_blocks b JOIN users u ON u.id = b.blocked_id " +
+		"WHERE b.user_id = $1 ORDER BY b.created_at DESC"
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []BlockRes{}
+	for rows.Next() {
+		var block BlockRes
+		user, err := scanUser(rows, &block.Kind, &block.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		block.User = user.Profile()
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// ListBlockedIDs returns the IDs of the users the user blocked. Muted users are not part of it.
+func (r *repository) ListBlockedIDs(ctx context.Context, userID int64) ([]int64, error) {
+	query := "SELECT blocked_id FROM user_blocks WHERE user_id = $1 AND kind = 'block'"
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetBlock inserts the relation or changes its kind when the user already blocked or muted blockedID.
+func (r *repository) SetBlock(ctx context.Context, userID int64, blockedID int64, kind string) error {
+	query := "INSERT INTO user_blocks (user_id, blocked_id, kind) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (user_id, blocked_id) DO UPDATE SET kind = EXCLUDED.kind, created_at = now()"
+
+	_, err := r.db.ExecContext(ctx, query, userID, blockedID, kind)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// DeleteBlock removes the block or mute of blockedID by the user.
+func (r *repository) DeleteBlock(ctx context.Context, userID int64, blockedID int64) error {
+	query := "DELETE FROM user_blocks WHERE user_id = $1 AND blocked_id = $2"
+
+	res, err := r.db.ExecContext(ctx, query, userID, blockedID)
+	err = rowAffected(res, err)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrBlockNotFound
+	}
+	return err
+}
+
+// contactColumns lists the columns of the contacts table in the order scanContact reads them.
+const contactColumns = "requester_id, addressee_id, status, created_at, accepted_at"
+
+// GetContact returns the row between the two users, whichever of them sent the request.
+func (r *repository) GetContact(ctx context.Context, userID int64, otherID int64) (Contact, error) {
+	query := "SELECT " + contactColumns + " FROM contacts " +
+		"WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)"
+
+	contact := Contact{}
+	err := r.db.QueryRowContext(ctx, query, userID, otherID).Scan(&contact.RequesterID, &contact.AddresseeID, &contact.Status, &contact.CreatedAt, &contact.AcceptedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Contact{}, ErrContactNotFound
+	}
+	return contact, err
+}
+
+// CreateContactRequest inserts a pending friend request. The contacts_pair_key index refuses a second row
+// for the same pair, whatever its direction.
+func (r *repository) CreateContactRequest(ctx context.Context, requesterID int64, addresseeID int64) error {
+	query := "INSERT INTO contacts (requester_id, addressee_id) VALUES ($1, $2)"
+
+	_, err := r.db.ExecContext(ctx, query, requesterID, addresseeID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case uniqueViolation:
+			return ErrContactRequestExists
+		case foreignKeyViolation:
+			return ErrUserNotFound
+		}
+	}
+	return err
+}
+
+// AcceptContactRequest marks the pending request from requesterID to addresseeID as accepted.
+func (r *repository) AcceptContactRequest(ctx context.Context, requesterID int64, addresseeID int64) error {
+	query := "UPDATE contacts SET status = 'accepted', accepted_at = now() WHERE requester_id = $1 AND addressee_id = $2 AND status = 'pending'"
+
+	res, err := r.db.ExecContext(ctx, query, requesterID, addresseeID)
+	err = rowAffected(res, err)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrContactRequestNotFound
+	}
+	return err
+}
+
+// DeleteContactRequest deletes the pending request from requesterID to addresseeID.
+func (r *repository) DeleteContactRequest(ctx context.Context, requesterID int64, addresseeID int64) error {
+	query := "DELETE FROM contacts WHERE requester_id = $1 AND addressee_id = $2 AND status = 'pending'"
+
+	res, err := r.db.ExecContext(ctx, query, requesterID, addresseeID)
+	err = rowAffected(res, err)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrContactRequestNotFound
+	}
+	return err
+}
+
+// DeleteContact deletes the accepted contact between the two users.
+func (r *repository) DeleteContact(ctx context.Context, userID int64, otherID int64) error {
+	query := "DELETE FROM contacts WHERE ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)) " +
+		"AND status = 'accepted'"
+
+	res, err := r.db.ExecContext(ctx, query, userID, otherID)
+	err = rowAffected(res, err)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrContactNotFound
+	}
+	return err
+}
+
+// DeleteContacts deletes the row between the two users, accepted or pending.
+func (r *repository) DeleteContacts(ctx context.Context, userID int64, otherID int64) error {
+	query := "DELETE FROM contacts WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)"
+
+	_, err := r.db.ExecContext(ctx, query, userID, otherID)
+	return err
+}
+
+// ListContacts returns the users who accepted a request of the user or whose request the user accepted,
+// most recently accepted first.
+func (r *repository) ListContacts(ctx context.Context, userID int64) ([]ContactRes, error) {
+	query := "SELECT " + prefixColumns("u", userColumns) + ", c.accepted_at FROM contacts c " +
+		"JOIN users u ON u.id = CASE WHEN c.requester_id = $1 THEN c.addressee_id ELSE c.requester_id END " +
+		"WHERE (c.requester_id = $1 OR c.addressee_id = $1) AND c.status = 'accepted' ORDER BY c.accepted_at DESC"
+
+	return r.queryContacts(ctx, query, userID)
+}
+
+// ListContactRequests returns the pending requests received by the user, or sent by them when incoming is false,
+// newest first.
+func (r *repository) ListContactRequests(ctx context.Context, userID int64, incoming bool) ([]ContactRes, error) {
+	query := "SELECT " + prefixColumns("u", userColumns) + ", c.created_at FROM contacts c " +
+		"JOIN users u ON u.id = c.requester_id WHERE c.addressee_id = $1 AND c.status = 'pending' ORDER BY c.created_at DESC"
+	if !incoming {
+		query = "SELECT " + prefixColumns("u", userColumns) + ", c.created_at FROM contacts c " +
+			"JOIN users u ON u.id = c.addressee_id WHERE c.requester_id = $1 AND c.status = 'pending' ORDER BY c.created_at DESC"
+	}
+
+	return r.queryContacts(ctx, query, userID)
+}
+
+// queryContacts reads the rows of a query selecting userColumns and the time of the relation.
+func (r *repository) queryContacts(ctx context.Context, query string, args ...interface{}) ([]ContactRes, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []ContactRes{}
+	for rows.Next() {
+		var contact ContactRes
+		user, err := scanUser(rows, &contact.Since)
+		if err != nil {
+			return nil, err
+		}
+		contact.User = user.Profile()
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+// DeleteAccessTokens deletes every personal access token of the user.
+func (r *repository) DeleteAccessTokens(ctx context.Context, userID int64) error {
+	query := "DELETE FROM personal_access_tokens WHERE user_id = $1"
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// CreateBot inserts a bot account without email address or password, and returns the stored row.
+// The owner's row stays locked from the count to the insert, so concurrent requests cannot exceed maxBots.
+func (r *repository) CreateBot(ctx context.Context, bot *User, maxBots int) (User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT id FROM users WHERE id = $1 FOR UPDATE"
+	var ownerID int64
+	if err := tx.QueryRowContext(ctx, query, bot.BotOwnerID).Scan(&ownerID); err != nil {
+		return User{}, mapUserError(err)
+	}
+
+	query = "SELECT count(*) FROM users WHERE bot_owner_id = $1 AND is_bot"
+	var count int
+	if err := tx.QueryRowContext(ctx, query, ownerID).Scan(&count); err != nil {
+		return User{}, err
+	}
+	if count >= maxBots {
+		return User{}, ErrTooManyBots
+	}
+
+	query = "INSERT INTO users (username, email, password, display_name, is_bot, bot_owner_id) VALUES ($1, '', '', $2, true, $3) returning " + userColumns
+	created, err := scanUser(tx.QueryRowContext(ctx, query, bot.Username, bot.DisplayName, ownerID))
+	if err != nil {
+		return User{}, mapUserError(err)
+	}
+	return created, tx.Commit()
+}
+
+// ListBots returns the bots owned by the user, oldest first.
+func (r *repository) ListBots(ctx context.Context, ownerID int64) ([]User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE bot_owner_id = $1 AND is_bot ORDER BY id"
+
+	rows, err := r.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bots := []User{}
+	for rows.Next() {
+		bot, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, rows.Err()
+}
+
+// GetBot returns the bot with the given ID, only if it belongs to the user.
+func (r *repository) GetBot(ctx context.Context, ownerID int64, botID int64) (User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1 AND bot_owner_id = $2 AND is_bot"
+
+	bot, err := scanUser(r.db.QueryRowContext(ctx, query, botID, ownerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrBotNotFound
+	}
+	return bot, err
+}
+
+// DeleteBot deletes the bot with the given ID, only if it belongs to the user.
+// Its access tokens are deleted with it by their ON DELETE CASCADE reference.
+func (r *repository) DeleteBot(ctx context.Context, ownerID int64, botID int64) error {
+	query := "DELETE FROM users WHERE id = $1 AND bot_owner_id = $2 AND is_bot"
+
+	res, err := r.db.ExecContext(ctx, query, botID, ownerID)
+	err = rowAffected(res, err)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrBotNotFound
+	}
+	return err
+}
+
+// PurgeDeletedUsers deletes the users whose delete_after is not after now, and the bots they own.
+// Tokens, sessions, recovery codes and identities are deleted with them by their ON DELETE CASCADE references.
+func (r *repository) PurgeDeletedUsers(ctx context.Context, now time.Time) ([]User, error) {
+	query := "DELETE FROM users WHERE delete_after <= $1 OR bot_owner_id IN (SELECT id FROM users WHERE delete_after <= $1) returning " + userColumns
+
+	rows, err := r.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var purged []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		purged = append(purged, user)
+	}
+	return purged, rows.Err()
+}
+
+// CreateIdentity links an external identity to a user.
+func (r *repository) CreateIdentity(ctx context.Context, identity *Identity) error {
+	query := "INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) returning id, created_at"
+
+	return r.db.QueryRowContext(ctx, query, identity.UserID, identity.Issuer, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// UpdateProfile writes the profile fields of the user and returns the stored row.
+func (r *repository) UpdateProfile(ctx context.Context, user *User) (User, error) {
+	query := "UPDATE users SET display_name = $1, bio = $2, avatar_url = $3, timezone = $4, updated_at = now() WHERE id = $5 returning " + userColumns
+
+	updated, err := scanUser(r.db.QueryRowContext(ctx, query, user.DisplayName, user.Bio, user.AvatarURL, user.Timezone, user.ID))
+	return updated, mapUserError(err)
+}
+
+// UpdateEmail replaces the email address of the user, which then needs to be verified again.
+func (r *repository) UpdateEmail(ctx context.Context, userID int64, email string) error {
+	query := "UPDATE users SET email = $1, email_verified = false, updated_at = now() WHERE id = $2"
+
+	_, err := r.db.ExecContext(ctx, query, email, userID)
+	return mapUserError(err)
+}
+
+// uniqueViolation is the PostgreSQL error code of a unique constraint or unique index violation.
+const uniqueViolation = "23505"
+
+// foreignKeyViolation is the PostgreSQL error code of a reference to a row that does not exist.
+const foreignKeyViolation = "23503"
+
+// mapUserError translates the database errors of queries on the users table into domain errors:
+// sql.ErrNoRows becomes ErrUserNotFound and violations of the unique indexes become ErrEmailTaken or ErrUsernameTaken.
+// Other errors, and nil, are returned unchanged.
+func mapUserError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		switch pqErr.Constraint {
+		case "users_email_key":
+			return ErrEmailTaken
+		case "users_username_key":
+			return ErrUsernameTaken
+		}
+	}
+	return err
+}
+
+// prefixColumns qualifies every column of a comma separated list with a table alias.
+func prefixColumns(alias string, columns string) string {
+	cols := strings.Split(columns, ", ")
+	for i, col := range cols {
+		cols[i] = alias + "." + col
+	}
+	return strings.Join(cols, ", ")
+}
@@ -2,35 +2,217 @@
 package users
 
 import (
-	"context"              // Provides a context object that carries deadlines, cancellation signals, and other request-scoped values across API boundaries and between processes.
-	"server/internal/util" // Provides utility functions for the application.
-	"strconv"              // Provides functions for converting between string and numeric types.
-	"time"                 // Provides functionality for measuring and displaying time.
+	"context"                  // Provides a context object that carries deadlines, cancellation signals, and other request-scoped values across API boundaries and between processes.
+	"database/sql"             // Provides sql.ErrNoRows for missing rows.
+	"errors"                   // Provides errors.Is for matching sentinel errors.
+	"log"                      // Provides logging of errors that do not fail the request.
+	"server/config"            // Provides the server configuration.
+	"server/internal/audit"    // Provides the authentication audit log.
+	"server/internal/mailer"   // Provides email delivery.
+	"server/internal/throttle" // Provides brute-force protection of the login.
+	"server/internal/util"     // Provides utility functions for the application.
+	"strconv"                  // Provides functions for converting between string and numeric types.
+	"strings"                  // Provides strings.HasPrefix to tell personal access tokens apart.
+	"time"                     // Provides functionality for measuring and displaying time.
 
 	"github.com/golang-jwt/jwt/v4" // Provides JWT credentials
 )
 
 // service is a struct that contains a Repository and a timeout duration.
 type service struct {
-	Repository               // Represents a database or other storage system that the user service can use to store and retrieve user data.
-	timeout    time.Duration // Represents the maximum amount of time that the user service will wait for a database operation to complete.
+	Repository                   // Represents a database or other storage system that the user service can use to store and retrieve user data.
+	timeout    time.Duration     // Represents the maximum amount of time that the user service will wait for a database operation to complete.
+	revoked    *revocationCache  // Caches the revoked_sessions table.
+	terminator SessionTerminator // Closes the live connections of revoked sessions, may be nil.
+	keys       *KeySet           // Signs and verifies session tokens.
+	issuer     string            // The "iss" claim of session tokens.
+	accessTTL  time.Duration     // Lifetime of access tokens.
+	refreshTTL time.Duration     // Lifetime of refresh tokens.
+
+	mailer           mailer.Mailer // Delivers verification emails.
+	baseURL          string        // Public URL used to build links in emails.
+	unverifiedEmail  string        // config.UnverifiedBlock or config.UnverifiedLimit.
+	verificationTTL  time.Duration // Lifetime of email verification links.
+	passwordResetTTL time.Duration // Lifetime of password reset links.
+	magicLinkTTL     time.Duration // Lifetime of passwordless login links.
+
+	appName           string          // Issuer shown by authenticator apps.
+	loginChallengeTTL time.Duration   // Lifetime of the challenge between the password and the two-factor step.
+	totpBox           *util.SecretBox // Encrypts TOTP secrets, nil when no key is configured.
+
+	oidc *oidcProvider // OpenID Connect identity provider, nil when disabled.
+
+	guard *throttle.Guard // Slows down and locks out password guessing.
+
+	passwordPolicy util.PasswordPolicy // Checked every time a password is set.
+	passwordHasher util.PasswordHasher // Hashes new passwords and tells which stored hashes are outdated.
+	dummyHash      string              // Compared against when logging in with an unknown email.
+
+	content       UserContent   // Chat content of the users, may be nil.
+	deletionGrace time.Duration // How long a deleted account can be restored.
+
+	presence PresenceSource  // Live presence of the users, may be nil.
+	blocks   BlockCache      // Blocked users of the connected users, may be nil.
+	contacts ContactNotifier // Pushes friend requests to the connected users, may be nil.
+
+	auditLog *audit.Log // Records authentication events, may be nil.
+
+	connections ConnectionCounter // Counts the open WebSockets of each session, may be nil.
+}
+
+// Option configures optional dependencies of the user service.
+type Option func(*service)
+
+// WithSessionTerminator makes the service close the live connections of every session it revokes.
+func WithSessionTerminator(t SessionTerminator) Option {
+	return func(s *service) {
+		s.terminator = t
+	}
+}
+
+// WithMailer sets the Mailer used for account emails. The default logs them.
+func WithMailer(m mailer.Mailer) Option {
+	return func(s *service) {
+		s.mailer = m
+	}
+}
+
+// WithUserContent makes account deletion disconnect the user from the chat and anonymize their rooms,
+// and adds the rooms to the personal data export.
+func WithUserContent(uc UserContent) Option {
+	return func(s *service) {
+		s.content = uc
+	}
+}
+
+// WithPresence sets where GetPresence reads whether users are connected.
+func WithPresence(p PresenceSource) Option {
+	return func(s *service) {
+		s.presence = p
+	}
+}
+
+// WithBlockCache makes the service push every change of a user's blocks to the chat.
+func WithBlockCache(b BlockCache) Option {
+	return func(s *service) {
+		s.blocks = b
+	}
+}
+
+// WithContactNotifier makes the service push friend requests and their answers to the users' open WebSockets.
+func WithContactNotifier(n ContactNotifier) Option {
+	return func(s *service) {
+		s.contacts = n
+	}
 }
 
-// NewService creates a new user service with the given repository and timeout duration.
-func NewService(repository Repository) Service {
-	return &service{
-		repository,
-		time.Duration(2) * time.Second, // Sets the timeout duration to 2 seconds.
+// WithLoginGuard sets the Guard that throttles failed logins. The default keeps its counters in memory,
+// which is only correct with a single server node.
+func WithLoginGuard(g *throttle.Guard) Option {
+	return func(s *service) {
+		s.guard = g
 	}
 }
 
+// WithAuditLog makes the service record registrations, logins, logouts, password changes and token creations.
+func WithAuditLog(l *audit.Log) Option {
+	return func(s *service) {
+		s.auditLog = l
+	}
+}
+
+// WithConnectionCounter sets where ListSessions reads how many WebSockets each session has open.
+func WithConnectionCounter(cc ConnectionCounter) Option {
+	return func(s *service) {
+		s.connections = cc
+	}
+}
+
+// NewService creates a new user service with the given repository and configuration.
+// It returns an error when the configured JWT keys or breached password corpus cannot be loaded.
+func NewService(repository Repository, cfg *config.Config, opts ...Option) (Service, error) {
+	keys, err := NewKeySet(cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := util.PasswordPolicy{
+		MinLength:            cfg.Auth.Password.MinLength,
+		MaxLength:            cfg.Auth.Password.MaxLength,
+		MinCharClasses:       cfg.Auth.Password.MinCharClasses,
+		DisallowPersonalInfo: cfg.Auth.Password.DisallowPersonalInfo,
+	}
+	if cfg.Auth.Password.BreachedDir != "" {
+		policy.Breached, err = util.OpenBreachedPasswords(cfg.Auth.Password.BreachedDir, cfg.Auth.Password.BreachedMinCount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	totpBox, err := newTOTPBox(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &service{
+		Repository: repository,
+		timeout:    time.Duration(2) * time.Second, // Sets the timeout duration to 2 seconds.
+		revoked:    newRevocationCache(),
+		keys:       keys,
+		issuer:     cfg.JWT.Issuer,
+		accessTTL:  cfg.JWT.AccessTokenTTL.Duration,
+		refreshTTL: cfg.JWT.RefreshTokenTTL.Duration,
+
+		mailer:           &mailer.LogMailer{From: cfg.Mail.From},
+		baseURL:          cfg.App.BaseURL,
+		unverifiedEmail:  cfg.Auth.UnverifiedEmail,
+		verificationTTL:  cfg.Auth.EmailVerificationTTL.Duration,
+		passwordResetTTL: cfg.Auth.PasswordResetTTL.Duration,
+		magicLinkTTL:     cfg.Auth.MagicLinkTTL.Duration,
+
+		appName:           cfg.App.Name,
+		loginChallengeTTL: cfg.Auth.LoginChallengeTTL.Duration,
+		totpBox:           totpBox,
+
+		oidc: newOIDCProvider(cfg.OIDC),
+
+		guard: throttle.NewGuard(throttle.NewMemoryStore(), throttle.Rules(cfg.Throttle)),
+
+		passwordPolicy: policy,
+		passwordHasher: util.PasswordHasher{
+			Algorithm:         cfg.Auth.PasswordHash.Algorithm,
+			Argon2Memory:      cfg.Auth.PasswordHash.Argon2.MemoryKiB,
+			Argon2Iterations:  cfg.Auth.PasswordHash.Argon2.Iterations,
+			Argon2Parallelism: cfg.Auth.PasswordHash.Argon2.Parallelism,
+			BcryptCost:        cfg.Auth.PasswordHash.BcryptCost,
+		},
+
+		deletionGrace: cfg.Auth.AccountDeletionGrace.Duration,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// The dummy hash uses the current settings, so an unknown email takes as long as a real account.
+	s.dummyHash, err = s.passwordHasher.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 // CreateUser creates a new user in the system.
 func (s *service) CreateUser(c context.Context, req *CreateUserReq) (*CreateUserRes, error) {
 	ctx, cancel := context.WithTimeout(c, s.timeout) // Creates a new context that is a copy of the parent context but has a timeout set to the value of s.timeout.
 	defer cancel()                                   // Cancels the context when the function returns.
 
-	// Hashes the password provided in the request object using the util.HashPassword function.
-	hashpw, err := util.HashPassword(req.Password)
+	// Refuses passwords that are too weak, contain the username or email, or appear in a breach.
+	if err := s.passwordPolicy.Check(req.Password, req.Username, req.Email); err != nil {
+		return nil, err
+	}
+
+	// Hashes the password provided in the request object with the configured algorithm.
+	hashpw, err := s.passwordHasher.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +230,12 @@ func (s *service) CreateUser(c context.Context, req *CreateUserReq) (*CreateUser
 		return nil, err
 	}
 
+	// Sends the verification link; the account is usable even if this fails, the user can ask for a new link.
+	if err := s.sendVerificationEmail(ctx, *r); err != nil {
+		log.Printf("create user: send verification email: %v", err)
+	}
+	s.auditUserEvent(ctx, audit.TypeRegister, *r, nil)
+
 	// Creates a new response object with the ID, username, and email of the newly created user.
 	res := &CreateUserRes{
 		ID:       strconv.Itoa(int(r.ID)),
@@ -62,30 +250,351 @@ func (s *service) LoginUser(c context.Context, req *LoginUserReq) (LoginUserRes,
 
 	defer cancel()
 
+	// Failures count against the account and the client IP, unknown emails included,
+	// so a lockout does not reveal whether an account exists.
+	keys := []string{throttle.AccountKey(req.Email), throttle.IPKey(util.ClientInfoFrom(c).IP)}
+	if err := s.guard.Check(ctx, keys...); err != nil {
+		s.auditLoginFailed(ctx, req.Email, nil, loginMethodPassword, err)
+		return LoginUserRes{}, err
+	}
+
 	user, err := s.Repository.GetUserByEmail(ctx, req.Email)
+	if errors.Is(err, ErrUserNotFound) {
+		// Hash anyway, so the response time does not reveal whether the email is registered.
+		util.CheckPassword(s.dummyHash, req.Password)
+		s.auditLoginFailed(ctx, req.Email, nil, loginMethodPassword, ErrInvalidCredentials)
+		return LoginUserRes{}, s.loginFailed(ctx, keys, ErrInvalidCredentials)
+	}
 	if err != nil {
 		return LoginUserRes{}, err
 	}
 	err = util.CheckPassword(user.Password, req.Password)
 
 	if err != nil {
+		s.auditLoginFailed(ctx, req.Email, &user, loginMethodPassword, ErrInvalidCredentials)
+		return LoginUserRes{}, s.loginFailed(ctx, keys, ErrInvalidCredentials)
+	}
+	s.rehashPassword(ctx, user, req.Password)
+
+	if !user.EmailVerified && s.unverifiedEmail == config.UnverifiedBlock {
+		s.auditLoginFailed(ctx, req.Email, &user, loginMethodPassword, ErrEmailNotVerified)
+		return LoginUserRes{}, ErrEmailNotVerified
+	}
+
+	if err := s.refuseDeletedAccount(ctx, user, loginMethodPassword); err != nil {
 		return LoginUserRes{}, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, MyJWTClaims{
-		ID:       strconv.Itoa(int(user.ID)),
-		Username: user.Username,
+	// Accounts with two-factor authentication get a challenge instead of a session.
+	// Their failures are only forgotten once the second factor is accepted too.
+	if user.TOTPEnabled {
+		return s.startTwoFactorChallenge(ctx, user)
+	}
+
+	return s.loginSucceeded(ctx, user, loginMethodPassword)
+}
+
+// loginFailed counts a failed login attempt against keys and returns err.
+func (s *service) loginFailed(ctx context.Context, keys []string, err error) error {
+	if ferr := s.guard.Failure(ctx, keys...); ferr != nil {
+		log.Printf("login: count failure: %v", ferr)
+	}
+	return err
+}
+
+// loginSucceeded forgets the failed attempts on the account and starts the session.
+func (s *service) loginSucceeded(ctx context.Context, user User, method string) (LoginUserRes, error) {
+	if err := s.guard.Success(ctx, throttle.AccountKey(user.Email)); err != nil {
+		log.Printf("login: reset failures: %v", err)
+	}
+	return s.startSession(ctx, user, method)
+}
+
+// rehashPassword replaces a hash made with an old algorithm or old parameters, now that the password is known.
+// Failing to do so is only logged: the old hash still works and is replaced on a later login.
+func (s *service) rehashPassword(ctx context.Context, user User, password string) {
+	if !s.passwordHasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashpw, err := s.passwordHasher.Hash(password)
+	if err == nil {
+		err = s.Repository.UpdatePassword(ctx, user.ID, hashpw)
+	}
+	if err != nil {
+		log.Printf("login: rehash password of user %d: %v", user.ID, err)
+	}
+}
+
+// startSession creates a new session for a user who completed every login step with the given method.
+func (s *service) startSession(ctx context.Context, user User, method string) (LoginUserRes, error) {
+	if err := s.refuseDeletedAccount(ctx, user, method); err != nil {
+		return LoginUserRes{}, err
+	}
+	// Logging in during the grace period keeps an account its owner deleted.
+	if user.DeleteAfter != nil {
+		if err := s.cancelDeletion(ctx, user); err != nil {
+			return LoginUserRes{}, err
+		}
+	}
+
+	// Every login starts a new refresh token family.
+	familyID, err := util.GenerateToken(16)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	info := util.ClientInfoFrom(ctx)
+	err = s.Repository.CreateSession(ctx, &Session{ID: familyID, UserID: user.ID, IP: info.IP, UserAgent: info.UserAgent})
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	res, err := s.issueTokens(ctx, user, familyID)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+	s.recordAudit(ctx, audit.Event{
+		Type:      audit.TypeLogin,
+		UserID:    &user.ID,
+		Email:     user.Email,
+		SessionID: familyID,
+		Success:   true,
+		Details:   map[string]string{"method": method},
+	})
+	return res, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+// Refresh tokens are single use: presenting one that was already rotated revokes its whole family,
+// so a stolen token stops working for both the thief and the legitimate user.
+func (s *service) RefreshToken(c context.Context, refreshToken string) (LoginUserRes, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	rt, err := s.Repository.GetRefreshToken(ctx, util.HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginUserRes{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	if rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+		return LoginUserRes{}, ErrInvalidRefreshToken
+	}
+
+	if rt.UsedAt != nil {
+		return LoginUserRes{}, s.revokeReusedFamily(ctx, rt)
+	}
+
+	ok, err := s.Repository.UseRefreshToken(ctx, rt.ID)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+	if !ok {
+		// Another request rotated this token between our read and our update.
+		return LoginUserRes{}, s.revokeReusedFamily(ctx, rt)
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, rt.UserID)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	// A refresh is the last activity of the session shown in its device list; failing to record it is harmless.
+	info := util.ClientInfoFrom(ctx)
+	if err := s.Repository.TouchSession(ctx, rt.FamilyID, info.IP, info.UserAgent); err != nil {
+		log.Printf("refresh token: touch session: %v", err)
+	}
+
+	return s.issueTokens(ctx, user, rt.FamilyID)
+}
+
+// Logout revokes the session the given tokens belong to.
+// The session is taken from the access token when it is still valid, otherwise from the refresh token.
+// Unknown or invalid tokens are ignored: there is nothing left to log out.
+func (s *service) Logout(c context.Context, accessToken string, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if accessToken != "" {
+		if claims, err := s.ParseToken(ctx, accessToken); err == nil {
+			userID, err := strconv.ParseInt(claims.ID, 10, 64)
+			if err != nil {
+				return err
+			}
+			return s.logout(ctx, userID, claims.SessionID)
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+	rt, err := s.Repository.GetRefreshToken(ctx, util.HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.logout(ctx, rt.UserID, rt.FamilyID)
+}
+
+// logout revokes a session of the user and records it.
+func (s *service) logout(ctx context.Context, userID int64, sessionID string) error {
+	if err := s.revokeSessions(ctx, userID, RevokeReasonLogout, sessionID); err != nil {
+		return err
+	}
+	s.recordAudit(ctx, audit.Event{Type: audit.TypeLogout, UserID: &userID, SessionID: sessionID, Success: true})
+	return nil
+}
+
+// ChangePassword re-authenticates the user, stores the new password and revokes every other session of the user.
+// The session making the request stays logged in.
+func (s *service) ChangePassword(c context.Context, claims *MyJWTClaims, req *ChangePasswordReq) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	userID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// Checked first, so a refused password does not use up the two-factor code.
+	if err := s.passwordPolicy.Check(req.NewPassword, user.Username, user.Email); err != nil {
+		return err
+	}
+	if err := s.reauthenticate(ctx, user, req.CurrentPassword, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+
+	hashpw, err := s.passwordHasher.Hash(req.NewPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.UpdatePassword(ctx, userID, hashpw); err != nil {
+		return err
+	}
+	s.recordAudit(ctx, audit.Event{
+		Type:      audit.TypePasswordChange,
+		UserID:    &userID,
+		Email:     user.Email,
+		SessionID: claims.SessionID,
+		Success:   true,
+	})
+
+	return s.revokeAllSessions(ctx, userID, RevokeReasonPasswordChange, claims.SessionID)
+}
+
+// RevokeUserSessions revokes every session of the given user.
+func (s *service) RevokeUserSessions(c context.Context, userID int64, reason string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.revokeAllSessions(ctx, userID, reason)
+}
+
+// revokeReusedFamily revokes the session of a refresh token that was presented twice.
+func (s *service) revokeReusedFamily(ctx context.Context, rt RefreshToken) error {
+	if err := s.revokeSessions(ctx, rt.UserID, RevokeReasonRefreshReuse, rt.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// issueTokens signs a short-lived access token and stores a new refresh token in the given family.
+func (s *service) issueTokens(ctx context.Context, user User, familyID string) (LoginUserRes, error) {
+	now := time.Now()
+
+	jti, err := util.GenerateToken(16)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
+
+	ss, err := s.keys.Sign(MyJWTClaims{
+		ID:            strconv.Itoa(int(user.ID)),
+		Username:      user.Username,
+		SessionID:     familyID,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    strconv.Itoa(int(user.ID)),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			Issuer:    s.issuer,
+			Subject:   strconv.Itoa(int(user.ID)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
 		},
 	})
+	if err != nil {
+		return LoginUserRes{}, err
+	}
 
-	ss, err := token.SignedString([]byte("secret"))
+	refreshToken, err := util.GenerateToken(32)
+	if err != nil {
+		return LoginUserRes{}, err
+	}
 
+	_, err = s.Repository.CreateRefreshToken(ctx, &RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: util.HashToken(refreshToken),
+		ExpiresAt: now.Add(s.refreshTTL),
+	})
 	if err != nil {
 		return LoginUserRes{}, err
 	}
 
-	return LoginUserRes{access_token: ss, Username: user.Username, ID: strconv.Itoa(int(user.ID))}, nil
+	return LoginUserRes{
+		access_token:  ss,
+		access_ttl:    s.accessTTL,
+		refresh_token: refreshToken,
+		refresh_ttl:   s.refreshTTL,
+		Username:      user.Username,
+		ID:            strconv.Itoa(int(user.ID)),
+	}, nil
+}
+
+// ParseToken validates the signature and expiry of a token issued by LoginUser and returns its claims.
+// Tokens of revoked sessions are rejected with ErrSessionRevoked.
+// Personal access tokens are accepted too, with their scopes in the claims.
+func (s *service) ParseToken(c context.Context, tokenString string) (*MyJWTClaims, error) {
+	if strings.HasPrefix(tokenString, AccessTokenPrefix) {
+		ctx, cancel := context.WithTimeout(c, s.timeout)
+		defer cancel()
+
+		return s.parseAccessToken(ctx, tokenString)
+	}
+
+	claims := &MyJWTClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(s.issuer, true) {
+		return nil, jwt.ErrTokenInvalidIssuer
+	}
+
+	if claims.SessionID == "" {
+		return nil, ErrSessionRevoked
+	}
+	revoked, err := s.isSessionRevoked(c, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrSessionRevoked
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys that verify session tokens.
+func (s *service) JWKS() JWKS {
+	return s.keys.JWKS()
 }
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"server/internal/mailer"
+	"server/internal/throttle"
+	"server/internal/util"
+	"strings"
+	"time"
+)
+
+// VerifyEmail consumes an email verification token and marks the address of its user as verified.
+func (s *service) VerifyEmail(c context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	ut, err := s.Repository.ConsumeUserToken(ctx, TokenPurposeEmailVerification, util.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.Repository.SetEmailVerified(ctx, ut.UserID)
+}
+
+// ResendVerification sends a new verification link and invalidates the previous ones.
+// It returns nil for unknown and already verified addresses so callers cannot probe for accounts.
+// Like ForgotPassword, every request counts against the throttle.MailKey of the address and the client IP.
+func (s *service) ResendVerification(c context.Context, email string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	keys := []string{throttle.MailKey(TokenPurposeEmailVerification, email), throttle.MailIPKey(util.ClientInfoFrom(c).IP)}
+	if err := s.guard.Check(ctx, keys...); err != nil {
+		return err
+	}
+	if err := s.guard.Failure(ctx, keys...); err != nil {
+		log.Printf("resend verification: count request: %v", err)
+	}
+
+	user, err := s.Repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	if err := s.Repository.DeleteUserTokens(ctx, user.ID, TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+	return s.sendVerificationEmail(ctx, user)
+}
+
+// sendVerificationEmail creates a verification token for the user and mails the link to them.
+func (s *service) sendVerificationEmail(ctx context.Context, user User) error {
+	token, err := s.createUserToken(ctx, user.ID, TokenPurposeEmailVerification, s.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, s.link("/verify-email", token), s.verificationTTL),
+	})
+	return nil
+}
+
+// createUserToken stores a new single-use token and returns its plain value.
+func (s *service) createUserToken(ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, error) {
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.Repository.CreateUserToken(ctx, &UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// link builds an absolute link to path on this server carrying the token.
+func (s *service) link(path string, token string) string {
+	return strings.TrimRight(s.baseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendMail delivers the message in the background.
+// Delivery never blocks or fails the request, and its duration does not reveal whether an account exists.
+func (s *service) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.Printf("mailer: send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedQueryParams are the query parameters whose value is left out of the access log.
+// They carry the single-use tokens of emailed links, which grant a login or a verification to whoever reads them.
+var redactedQueryParams = map[string]bool{"token": true}
+
+// AccessLogger is gin's request logger, in gin's default format, with the values of redactedQueryParams
+// replaced by "REDACTED".
+func AccessLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(p gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			p.StatusCode,
+			p.Latency.Round(time.Microsecond),
+			p.ClientIP,
+			p.Method,
+			RedactQuery(p.Path),
+			p.ErrorMessage,
+		)
+	})
+}
+
+// RedactQuery replaces the values of redactedQueryParams in the query string of a request path.
+func RedactQuery(path string) string {
+	base, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if key, err := url.QueryUnescape(name); err != nil || redactedQueryParams[strings.ToLower(key)] {
+			params[i] = name + "=REDACTED"
+		}
+	}
+	return base + "?" + strings.Join(params, "&")
+}
@@ -0,0 +1,22 @@
+package util
+
+import "testing"
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/login", "/login"},
+		{"/verify-email?token=abc", "/verify-email?token=REDACTED"},
+		{"/login/magic-link/redeem?a=1&token=abc&b=2", "/login/magic-link/redeem?a=1&token=REDACTED&b=2"},
+		{"/verify-email?TOKEN=abc", "/verify-email?TOKEN=REDACTED"},
+		{"/verify-email?%74oken=abc", "/verify-email?%74oken=REDACTED"},
+		{"/users?q=token", "/users?q=token"},
+	}
+	for _, tt := range tests {
+		if got := RedactQuery(tt.path); got != tt.want {
+			t.Errorf("RedactQuery(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
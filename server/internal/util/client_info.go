@@ -0,0 +1,41 @@
+package util
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientInfo describes the client that sent a request.
+type ClientInfo struct {
+	// IP is the client address, taken from X-Forwarded-For only behind a trusted proxy.
+	IP string
+
+	// UserAgent is the User-Agent header of the request.
+	UserAgent string
+}
+
+// clientInfoKey is the context key of the ClientInfo.
+type clientInfoKey struct{}
+
+// WithClientInfo returns a copy of ctx carrying info.
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFrom returns the ClientInfo stored in ctx, or a zero ClientInfo.
+func ClientInfoFrom(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
+
+// ClientInfoMiddleware is a gin middleware that stores the ClientInfo in the request context,
+// so services can read it without depending on gin.
+func ClientInfoMiddleware(c *gin.Context) {
+	ctx := WithClientInfo(c.Request.Context(), ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
@@ -1,27 +1,169 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword hashes the given password using bcrypt's GenerateFromPassword function.
-// It returns the hashed password as a string and an error if any occurs during the hashing process.
-// The bcrypt.DefaultCost is used as the cost factor for the hashing algorithm.
+// Password hash algorithms.
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// argon2SaltLength and argon2KeyLength are the sizes in bytes of the salt and the derived key.
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrPasswordMismatch is returned by CheckPassword when the password does not match the hash.
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes passwords with the configured algorithm and parameters.
+//
+// argon2id hashes are stored in the PHC string format, which records the algorithm, its version
+// and its parameters with the hash: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+// bcrypt hashes ($2a$, $2b$ or $2y$) record their cost. CheckPassword verifies both, so changing
+// the algorithm or its parameters keeps old hashes working until NeedsRehash replaces them.
+type PasswordHasher struct {
+	// Algorithm is AlgorithmArgon2id or AlgorithmBcrypt.
+	Algorithm string
+
+	// Argon2Memory is the memory used by argon2id in KiB.
+	Argon2Memory uint32
+
+	// Argon2Iterations is the number of passes of argon2id over the memory.
+	Argon2Iterations uint32
+
+	// Argon2Parallelism is the number of threads used by argon2id.
+	Argon2Parallelism uint8
+
+	// BcryptCost is the cost factor of bcrypt.
+	BcryptCost int
+}
+
+// DefaultPasswordHasher is used by HashPassword.
+var DefaultPasswordHasher = PasswordHasher{
+	Algorithm:         AlgorithmArgon2id,
+	Argon2Memory:      64 * 1024,
+	Argon2Iterations:  3,
+	Argon2Parallelism: 2,
+	BcryptCost:        bcrypt.DefaultCost,
+}
+
+// HashPassword hashes the given password with DefaultPasswordHasher.
 func HashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return DefaultPasswordHasher.Hash(password)
+}
 
-	if err != nil {
-		return "", fmt.Errorf("Error on Hash Password: %w", err)
+// Hash hashes the given password with the algorithm and parameters of h.
+func (h PasswordHasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("Error on Hash Password: %w", err)
+		}
+		key := argon2.IDKey([]byte(password), salt, h.Argon2Iterations, h.Argon2Memory, h.Argon2Parallelism, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Argon2Memory, h.Argon2Iterations, h.Argon2Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+
+	case AlgorithmBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("Error on Hash Password: %w", err)
+		}
+		return string(hashed), nil
 	}
+	return "", fmt.Errorf("Error on Hash Password: unknown algorithm %q", h.Algorithm)
+}
 
-	return string(hashed), nil
+// NeedsRehash reports whether the hash was made with another algorithm or other parameters than h,
+// so it should be replaced the next time the password is known, i.e. after a successful login.
+func (h PasswordHasher) NeedsRehash(hash string) bool {
+	if isBcryptHash(hash) {
+		if h.Algorithm != AlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.BcryptCost
+	}
+
+	params, _, _, err := parseArgon2idHash(hash)
+	if err != nil || h.Algorithm != AlgorithmArgon2id {
+		return true
+	}
+	return params.version != argon2.Version ||
+		params.memory != h.Argon2Memory ||
+		params.iterations != h.Argon2Iterations ||
+		params.parallelism != h.Argon2Parallelism
 }
 
-// CheckPassword compares the given password with the hashed password.
+// CheckPassword compares the given password with the hashed password, which may be an argon2id or a bcrypt hash.
 // It returns an error if the password does not match the hashed password.
 func CheckPassword(hashpw string, password string) error {
-	// panic(fmt.Sprintf("Password %s, hashed password %s", password, hashpw))
-	return bcrypt.CompareHashAndPassword([]byte(hashpw), []byte(password))
+	if isBcryptHash(hashpw) {
+		return bcrypt.CompareHashAndPassword([]byte(hashpw), []byte(password))
+	}
+
+	params, salt, key, err := parseArgon2idHash(hashpw)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// argon2idParams are the parameters recorded in an argon2id hash.
+type argon2idParams struct {
+	version     int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// parseArgon2idHash splits an argon2id PHC string into its parameters, salt and key.
+func parseArgon2idHash(hash string) (argon2idParams, []byte, []byte, error) {
+	var params argon2idParams
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, errors.New("Error on Check Password: unknown hash format")
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &params.version); err != nil {
+		return params, nil, nil, fmt.Errorf("Error on Check Password: %w", err)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("Error on Check Password: %w", err)
+	}
+	// argon2.IDKey panics without a pass or a thread, and uses at least 8 KiB per thread.
+	if params.iterations < 1 || params.parallelism < 1 || params.memory < 8*uint32(params.parallelism) {
+		return params, nil, nil, errors.New("Error on Check Password: invalid parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("Error on Check Password: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("Error on Check Password: invalid key")
+	}
+	return params, salt, key, nil
+}
+
+// isBcryptHash reports whether the hash uses one of the bcrypt prefixes.
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
 }
@@ -0,0 +1,177 @@
+package util
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// ErrWeakPassword is returned, wrapped with the reasons, when a password does not follow the policy.
+	ErrWeakPassword = errors.New("password does not meet the requirements")
+	// ErrBreachedPassword is returned when a password appears in the breached password corpus.
+	ErrBreachedPassword = errors.New("password appears in a known data breach")
+)
+
+// PasswordPolicy is checked every time a password is set.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int
+
+	// MaxLength is the maximum size in bytes, 0 for no limit.
+	MaxLength int
+
+	// MinCharClasses is how many of lowercase letters, uppercase letters, digits and symbols must be used.
+	MinCharClasses int
+
+	// DisallowPersonalInfo refuses passwords that contain the username or email given to Check.
+	DisallowPersonalInfo bool
+
+	// Breached is the breached password corpus, nil disables the check.
+	Breached *BreachedPasswords
+}
+
+// PasswordPolicyError lists every requirement a password failed. It matches ErrWeakPassword with errors.Is.
+type PasswordPolicyError struct {
+	Reasons []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return ErrWeakPassword.Error() + ": " + strings.Join(e.Reasons, ", ")
+}
+
+// Is makes errors.Is(err, ErrWeakPassword) report true.
+func (e *PasswordPolicyError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+// Check returns a *PasswordPolicyError when the password does not follow the policy,
+// or ErrBreachedPassword when it appears in the breached corpus.
+// personal lists the username and email of the account, which the password must not contain.
+func (p PasswordPolicy) Check(password string, personal ...string) error {
+	var reasons []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		reasons = append(reasons, fmt.Sprintf("must be at most %d bytes long", p.MaxLength))
+	}
+	if charClasses(password) < p.MinCharClasses {
+		reasons = append(reasons, fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses))
+	}
+	if p.DisallowPersonalInfo && containsPersonalInfo(password, personal) {
+		reasons = append(reasons, "must not contain the username or email address")
+	}
+	if len(reasons) > 0 {
+		return &PasswordPolicyError{Reasons: reasons}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return ErrBreachedPassword
+		}
+	}
+	return nil
+}
+
+// charClasses counts which of lowercase letters, uppercase letters, digits and other characters s uses.
+func charClasses(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// containsPersonalInfo reports whether the password contains one of the personal values,
+// or the local part of an email address, ignoring case. Values shorter than 3 characters are ignored.
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			candidates = append(candidates, local)
+		}
+		for _, c := range candidates {
+			if utf8.RuneCountInString(c) >= 3 && strings.Contains(password, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BreachedPasswords looks passwords up in a local copy of a breached password corpus, so the check works
+// offline and no password or hash leaves the server. The corpus is split by SHA-1 prefix like the
+// haveibeenpwned range API: the file "21BD1.txt" holds the "SUFFIX:COUNT" lines of every hash starting with 21BD1.
+type BreachedPasswords struct {
+	dir      string
+	minCount int
+}
+
+// OpenBreachedPasswords opens the corpus in dir. Passwords seen fewer than minCount times are accepted.
+func OpenBreachedPasswords(dir string, minCount int) (*BreachedPasswords, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error on Open Breached Passwords: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Error on Open Breached Passwords: %s is not a directory", dir)
+	}
+	return &BreachedPasswords{dir: dir, minCount: max(minCount, 1)}, nil
+}
+
+// Contains reports whether the password was seen at least minCount times.
+// A missing prefix file means no breached password has that prefix.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error on Check Breached Passwords: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		// Padding lines of the corpus have a count of 0.
+		n, err := strconv.Atoi(count)
+		return err == nil && n >= b.minCount, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("Error on Check Breached Passwords: %w", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,126 @@
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 16, MinCharClasses: 3, DisallowPersonalInfo: true}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		wantErr  error
+	}{
+		{name: "valid", policy: policy, password: "Tr0ubadour"},
+		{name: "too short", policy: policy, password: "Tr0ub", wantErr: ErrWeakPassword},
+		{name: "length in characters", policy: policy, password: "Äpfel-99"},
+		{name: "too long", policy: policy, password: "Tr0ubadour-Tr0ubadour", wantErr: ErrWeakPassword},
+		{name: "too few classes", policy: policy, password: "troubadour", wantErr: ErrWeakPassword},
+		{name: "symbols count as a class", policy: policy, password: "troubad0ur!"},
+		{name: "username", policy: policy, password: "Jane-Doe-42", wantErr: ErrWeakPassword},
+		{name: "email local part", policy: policy, password: "X1-Jsmith.x", wantErr: ErrWeakPassword},
+		{name: "short personal values are ignored", policy: policy, password: "Tr0ubadjo"},
+		{name: "personal info allowed", policy: PasswordPolicy{MinLength: 8}, password: "jane-doe-42"},
+		{name: "no limits", policy: PasswordPolicy{}, password: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.password, "jane-doe", "jsmith@example.com", "jo")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyListsEveryReason(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MinCharClasses: 3, DisallowPersonalInfo: true}
+
+	var policyErr *PasswordPolicyError
+	if err := policy.Check("jane", "jane"); !errors.As(err, &policyErr) || len(policyErr.Reasons) != 3 {
+		t.Fatalf("err = %v, want 3 reasons", err)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	hashOf := func(password string) (string, string) {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		return hash[:5], hash[5:]
+	}
+
+	// The corpus is written like a download on Windows, with CRLF line ends, and padded with count 0 lines.
+	files := map[string][]string{}
+	add := func(password string, count string) {
+		prefix, suffix := hashOf(password)
+		files[prefix] = append(files[prefix], suffix+":"+count)
+	}
+	add("password", "3")
+	add("padding", "0")
+	for prefix, lines := range files {
+		if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Suffixes are compared without regard to case.
+	prefix, suffix := hashOf("hunter2")
+	if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte("0000000000000000000000000000000000A:9\r\n"+strings.ToLower(suffix)+":1\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		minCount int
+		password string
+		want     bool
+	}{
+		{name: "breached", minCount: 1, password: "password", want: true},
+		{name: "lowercase suffix", minCount: 1, password: "hunter2", want: true},
+		{name: "padding line", minCount: 1, password: "padding", want: false},
+		{name: "no prefix file", minCount: 1, password: "correct horse battery staple", want: false},
+		{name: "seen often enough", minCount: 3, password: "password", want: true},
+		{name: "seen too rarely", minCount: 4, password: "password", want: false},
+		{name: "min count below 1", minCount: 0, password: "padding", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breached, err := OpenBreachedPasswords(dir, tt.minCount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := breached.Contains(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+
+	policy := PasswordPolicy{MinLength: 8, Breached: mustOpenBreached(t, dir)}
+	if err := policy.Check("password"); !errors.Is(err, ErrBreachedPassword) {
+		t.Fatalf("err = %v, want ErrBreachedPassword", err)
+	}
+	if _, err := OpenBreachedPasswords(filepath.Join(dir, "missing"), 1); err == nil {
+		t.Fatal("opened a missing directory")
+	}
+}
+
+func mustOpenBreached(t *testing.T, dir string) *BreachedPasswords {
+	t.Helper()
+	breached, err := OpenBreachedPasswords(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return breached
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashes(t *testing.T) {
+	// Small parameters keep the test fast, they are recorded in the hash like the real ones.
+	argon := PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1}
+	stronger := argon
+	stronger.Argon2Iterations = 2
+	bcryptHasher := PasswordHasher{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}
+
+	argonHash, err := argon.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %q does not record its parameters", argonHash)
+	}
+	bcryptHash, err := bcryptHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, key := strings.Split(argonHash, "$")[4], strings.Split(argonHash, "$")[5]
+
+	tests := []struct {
+		name       string
+		hash       string
+		password   string
+		hasher     PasswordHasher
+		wantErr    bool
+		wantRehash bool
+	}{
+		{name: "argon2id", hash: argonHash, password: "correct horse", hasher: argon},
+		{name: "argon2id wrong password", hash: argonHash, password: "correct horsf", hasher: argon, wantErr: true},
+		{name: "argon2id with changed parameters", hash: argonHash, password: "correct horse", hasher: stronger, wantRehash: true},
+		{name: "argon2id with bcrypt configured", hash: argonHash, password: "correct horse", hasher: bcryptHasher, wantRehash: true},
+		{name: "bcrypt", hash: bcryptHash, password: "correct horse", hasher: bcryptHasher},
+		{name: "bcrypt wrong password", hash: bcryptHash, password: "correct horsf", hasher: bcryptHasher, wantErr: true},
+		{name: "bcrypt with argon2id configured", hash: bcryptHash, password: "correct horse", hasher: argon, wantRehash: true},
+		{name: "bcrypt with another cost", hash: bcryptHash, password: "correct horse", hasher: PasswordHasher{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost + 1}, wantRehash: true},
+		{name: "empty", hash: "", password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "unknown algorithm", hash: "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key, password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "missing part", hash: "$argon2id$v=19$m=64,t=1,p=1$" + salt, password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "no passes", hash: "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key, password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "no threads", hash: "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key, password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "too little memory", hash: "$argon2id$v=19$m=15,t=1,p=2$" + salt + "$" + key, password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "bad parameters", hash: "$argon2id$v=19$m=x,t=1,p=1$" + salt + "$" + key, password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "bad salt", hash: "$argon2id$v=19$m=64,t=1,p=1$!!$" + key, password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+		{name: "empty key", hash: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$", password: "correct horse", hasher: argon, wantErr: true, wantRehash: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPassword(tt.hash, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckPassword: err = %v, want error %v", err, tt.wantErr)
+			}
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.wantRehash {
+				t.Fatalf("NeedsRehash = %v, want %v", got, tt.wantRehash)
+			}
+		})
+	}
+
+	if err := CheckPassword(argonHash, "wrong"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("err = %v, want ErrPasswordMismatch", err)
+	}
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix starts every value sealed by a SecretBox, telling it apart from a value stored before encryption.
+const sealedPrefix = "enc:v1:"
+
+// SecretBox encrypts short secrets stored in the database, such as TOTP secrets, with AES-256-GCM.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a SecretBox from a base64 encoded 32 byte key.
+func NewSecretBox(key string) (*SecretBox, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("secret box key: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("secret box key: got %d bytes, want 32", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// IsSealed reports whether value was sealed by a SecretBox.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal encrypts plaintext. context is authenticated but not stored, e.g. the ID of the row,
+// so a sealed value copied to another row does not open.
+func (b *SecretBox) Seal(plaintext string, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value made by Seal with the same context.
+func (b *SecretBox) Open(value string, context string) (string, error) {
+	if !IsSealed(value) {
+		return "", errors.New("secret box: value is not sealed")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("secret box: %w", err)
+	}
+	if len(sealed) < b.aead.NonceSize() {
+		return "", errors.New("secret box: value is too short")
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", fmt.Errorf("secret box: %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	box, err := NewSecretBox(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP", "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("sealed value %q", sealed)
+	}
+
+	other, err := NewSecretBox(base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+
+	tests := []struct {
+		name    string
+		box     *SecretBox
+		value   string
+		context string
+		wantErr bool
+	}{
+		{name: "same context", box: box, value: sealed, context: "totp:1"},
+		{name: "other context", box: box, value: sealed, context: "totp:2", wantErr: true},
+		{name: "other key", box: other, value: sealed, context: "totp:1", wantErr: true},
+		{name: "tampered", box: box, value: tampered, context: "totp:1", wantErr: true},
+		{name: "not sealed", box: box, value: "JBSWY3DPEHPK3PXP", context: "totp:1", wantErr: true},
+		{name: "truncated", box: box, value: sealedPrefix + "AAAA", context: "totp:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.box.Open(tt.value, tt.context)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Open = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != "JBSWY3DPEHPK3PXP" {
+				t.Fatalf("Open = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestNewSecretBoxKey(t *testing.T) {
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("too short"))} {
+		if _, err := NewSecretBox(key); err == nil {
+			t.Errorf("NewSecretBox(%q) accepted the key", key)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors, "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("TOTPCode at %d = %q, %v, want %q", tt.unix, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", secret: rfcSecret, code: code(step), wantStep: step, wantOK: true},
+		{name: "previous step", secret: rfcSecret, code: code(step - 1), wantStep: step - 1, wantOK: true},
+		{name: "next step", secret: rfcSecret, code: code(step + 1), wantStep: step + 1, wantOK: true},
+		{name: "too old", secret: rfcSecret, code: code(step - 2)},
+		{name: "too new", secret: rfcSecret, code: code(step + 2)},
+		{name: "spaces", secret: rfcSecret, code: " " + code(step)[:3] + " " + code(step)[3:] + " ", wantStep: step, wantOK: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: code(step), wantStep: step, wantOK: true},
+		{name: "too short", secret: rfcSecret, code: code(step)[:5]},
+		{name: "too long", secret: rfcSecret, code: code(step) + "0"},
+		{name: "empty", secret: rfcSecret, code: ""},
+		{name: "invalid secret", secret: "not base32!", code: code(step)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Fatalf("ValidateTOTP = %d, %v, want %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package validation checks request bodies declared with `binding` tags and reports every invalid field
+// in a machine-readable form.
+//
+// The custom tags are registered on gin's validator by RegisterGin:
+//   - username: 3 to 32 letters, digits, dots, dashes or underscores
+//   - email_address: a bare address such as "jane@example.com" of at most 254 characters
+//   - room_id: 1 to 64 letters, digits, dashes or underscores
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// DefaultMaxBodySize is the request body limit used by the router.
+const DefaultMaxBodySize = 1 << 20
+
+// Length bounds of the "username" rule, in bytes since usernames are ASCII.
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+)
+
+var (
+	usernamePattern = regexp.MustCompile(fmt.Sprintf(`^[a-zA-Z0-9_.-]{%d,%d}$`, MinUsernameLength, MaxUsernameLength))
+	roomIDPattern   = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+)
+
+// FieldError describes one invalid field of a request.
+type FieldError struct {
+	// Field is the JSON name of the field, nested fields are separated by dots.
+	Field string `json:"field"`
+
+	// Code is the rule that failed, e.g. "required", "min" or "email_address".
+	Code string `json:"code"`
+
+	// Param is the parameter of the rule, e.g. "8" for min=8.
+	Param string `json:"param,omitempty"`
+
+	// Message explains the problem to a human.
+	Message string `json:"message"`
+}
+
+// ErrBodyTooLarge is returned when a request body exceeds the limit set by MaxBodySize.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// RegisterGin registers the custom tags on gin's default validator and makes FieldError.Field use JSON names.
+// It panics when the validator is not the go-playground one, which only happens after a gin upgrade.
+func RegisterGin() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		panic("validation: gin does not use go-playground/validator")
+	}
+	Register(v)
+}
+
+// Register registers the custom tags on v.
+func Register(v *validator.Validate) {
+	v.RegisterTagNameFunc(jsonName)
+
+	for tag, fn := range map[string]validator.Func{
+		"username":      func(fl validator.FieldLevel) bool { return Username(fl.Field().String()) },
+		"email_address": func(fl validator.FieldLevel) bool { return EmailAddress(fl.Field().String()) },
+		"room_id":       func(fl validator.FieldLevel) bool { return RoomID(fl.Field().String()) },
+	} {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Username reports whether s is a valid username.
+func Username(s string) bool {
+	return usernamePattern.MatchString(s)
+}
+
+// EmailAddress reports whether s is a bare email address, without a display name or surrounding spaces.
+func EmailAddress(s string) bool {
+	if len(s) > 254 {
+		return false
+	}
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// RoomID reports whether s is a valid room ID.
+func RoomID(s string) bool {
+	return roomIDPattern.MatchString(s)
+}
+
+// MaxBodySize is a gin middleware that limits request bodies to n bytes.
+// Reading past the limit fails with an error that IsBodyTooLarge recognizes.
+func MaxBodySize(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > n {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"code": "request_too_large", "error": ErrBodyTooLarge.Error()})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		c.Next()
+	}
+}
+
+// IsBodyTooLarge reports whether err comes from reading a body larger than the MaxBodySize limit.
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, ErrBodyTooLarge)
+}
+
+// Fields lists the invalid fields behind an error returned by gin's Bind or ShouldBind methods.
+// Malformed JSON and other errors that are not about a single field give no FieldError.
+func Fields(err error) []FieldError {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		fields := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			param := fe.Param()
+			if strings.HasPrefix(fe.Tag(), "required_with") {
+				// The parameter of required_with(out) names another field by its Go name.
+				param = snakeCase(param)
+			}
+			fields = append(fields, FieldError{
+				Field:   fieldPath(fe),
+				Code:    fe.Tag(),
+				Param:   param,
+				Message: message(fe),
+			})
+		}
+		return fields
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Param:   typeErr.Type.String(),
+			Message: "must be of type " + typeErr.Type.String(),
+		}}
+	}
+	return nil
+}
+
+// Message describes a binding error in one sentence that is safe to show to clients.
+func Message(err error) string {
+	var verrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &verrs), errors.As(err, &typeErr):
+		return "some fields are invalid"
+	case IsBodyTooLarge(err):
+		return ErrBodyTooLarge.Error()
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON"
+	case errors.Is(err, io.EOF):
+		return "request body is empty"
+	}
+	return err.Error()
+}
+
+// fieldPath returns the JSON path of the field without the name of the top level struct.
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if _, rest, ok := strings.Cut(ns, "."); ok {
+		return rest
+	}
+	return fe.Field()
+}
+
+// jsonName names struct fields after their JSON key, falling back to the form key and then the Go name.
+func jsonName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// snakeCase turns the Go field name in a rule parameter into its JSON name, e.g. "RecoveryCode" into "recovery_code".
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// message returns the human readable explanation of a failed rule.
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return "is required when " + snakeCase(fe.Param()) + " is missing"
+	case "min":
+		if fe.Kind() == reflect.String {
+			return "must be at least " + fe.Param() + " characters long"
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters long"
+		}
+		return "must be at most " + fe.Param()
+	case "len":
+		return "must be exactly " + fe.Param() + " characters long"
+	case "numeric":
+		return "must only contain digits"
+	case "username":
+		return "must be 3 to 32 letters, digits, dots, dashes or underscores"
+	case "email_address":
+		return "must be a valid email address"
+	case "room_id":
+		return "must be 1 to 64 letters, digits, dashes or underscores"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "url", "http_url":
+		return "must be a valid URL"
+	}
+	return "failed the " + fe.Tag() + " rule"
+}
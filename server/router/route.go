@@ -1,7 +1,10 @@
 package router
 
 import (
+	"server/config"
 	"server/internal/users"
+	"server/internal/util"
+	"server/internal/validation"
 	"server/ws"
 
 	"github.com/gin-gonic/gin"
@@ -10,19 +13,109 @@ import (
 var r *gin.Engine
 
 // NewRouter creates a new gin router.
-func InitHandler(userHandler *users.Handler, websocketHandler *ws.Handler) {
-	r = gin.Default()
+func InitHandler(cfg *config.Config, userHandler *users.Handler, websocketHandler *ws.Handler) error {
+	// gin.Default with an access log that leaves out the tokens of emailed links
+	r = gin.New()
+	r.Use(util.AccessLogger(), gin.Recovery())
+
+	// The client IP is only read from X-Forwarded-For when the request comes through a trusted proxy,
+	// otherwise anyone could spoof the address that login throttling counts against
+	if err := r.SetTrustedProxies(cfg.App.TrustedProxies); err != nil {
+		return err
+	}
+	r.Use(util.ClientInfoMiddleware)
+
+	// Request bodies are validated with `binding` tags, see package validation
+	validation.RegisterGin()
+	r.Use(validation.MaxBodySize(validation.DefaultMaxBodySize))
 
 	// Users Routings
 	r.POST("/register", userHandler.CreateUser)
 	r.POST("/login", userHandler.LoginUser)
+	r.POST("/login/2fa", userHandler.LoginTwoFactor)
+	r.POST("/login/magic-link", userHandler.RequestMagicLink)
+	r.GET("/login/magic-link/redeem", userHandler.MagicLinkPage)
+	r.POST("/login/magic-link/redeem", userHandler.LoginMagicLink)
+	r.GET("/oidc/login", userHandler.OIDCLogin)
+	r.GET("/oidc/callback", userHandler.OIDCCallback)
 	r.GET("/logout", userHandler.LogoutUser)
+	r.POST("/token/refresh", userHandler.RefreshToken)
+	r.GET("/.well-known/jwks.json", userHandler.JWKS)
+	r.GET("/verify-email", userHandler.VerifyEmail)
+	r.POST("/verify-email", userHandler.VerifyEmail)
+	r.POST("/resend-verification", userHandler.ResendVerification)
+	r.POST("/password/forgot", userHandler.ForgotPassword)
+	r.GET("/password/reset", userHandler.PasswordResetPage)
+	r.POST("/password/reset", userHandler.ResetPassword)
+
+	// Account Routings, only reachable with a valid session token.
+	// Personal access tokens may only use the routes that name their scope.
+	meRoutes := r.Group("/users/me", userHandler.RequireAuth)
+	meRoutes.GET("", userHandler.RequireScope(users.ScopeProfileRead), userHandler.GetMe)
+	meRoutes.PATCH("", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.UpdateProfile)
+	meRoutes.GET("/blocks", userHandler.RequireScope(users.ScopeProfileRead), userHandler.ListBlocks)
+	meRoutes.PUT("/blocks/:id", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.SetBlock)
+	meRoutes.DELETE("/blocks/:id", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.DeleteBlock)
+	meRoutes.GET("/contacts", userHandler.RequireScope(users.ScopeProfileRead), userHandler.ListContacts)
+	meRoutes.DELETE("/contacts/:id", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.DeleteContact)
+	meRoutes.GET("/contacts/requests", userHandler.RequireScope(users.ScopeProfileRead), userHandler.ListContactRequests)
+	meRoutes.POST("/contacts/requests", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.SendContactRequest)
+	meRoutes.POST("/contacts/requests/:id/accept", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.AcceptContactRequest)
+	meRoutes.POST("/contacts/requests/:id/decline", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.DeclineContactRequest)
+	meRoutes.DELETE("/contacts/requests/:id", userHandler.RequireScope(users.ScopeProfileWrite), userHandler.CancelContactRequest)
+
+	accountRoutes := meRoutes.Group("", userHandler.RequireSession)
+	accountRoutes.PUT("/password", userHandler.ChangePassword)
+	accountRoutes.PUT("/email", userHandler.ChangeEmail)
+	accountRoutes.DELETE("", userHandler.DeleteAccount)
+	accountRoutes.GET("/export", userHandler.ExportAccount)
+	accountRoutes.POST("/2fa/enroll", userHandler.EnrollTOTP)
+	accountRoutes.POST("/2fa/confirm", userHandler.ConfirmTOTP)
+	accountRoutes.POST("/2fa/disable", userHandler.DisableTOTP)
+	accountRoutes.GET("/tokens", userHandler.ListAccessTokens)
+	accountRoutes.POST("/tokens", userHandler.CreateAccessToken)
+	accountRoutes.DELETE("/tokens/:id", userHandler.DeleteAccessToken)
+	accountRoutes.GET("/sessions", userHandler.ListSessions)
+	accountRoutes.DELETE("/sessions", userHandler.DeleteAllSessions)
+	accountRoutes.DELETE("/sessions/:id", userHandler.DeleteSession)
+	accountRoutes.GET("/bots", userHandler.ListBots)
+	accountRoutes.POST("/bots", userHandler.RequireVerifiedEmail, userHandler.CreateBot)
+	accountRoutes.POST("/bots/:id/token", userHandler.ResetBotToken)
+	accountRoutes.DELETE("/bots/:id", userHandler.DeleteBot)
+
+	// Profiles of other users, only reachable with a valid session token
+	r.GET("/users", userHandler.RequireAuth, userHandler.RequireScope(users.ScopeUsersRead), userHandler.SearchUsers)
+	r.GET("/users/:id", userHandler.RequireAuth, userHandler.RequireScope(users.ScopeUsersRead), userHandler.GetProfile)
+	r.GET("/users/:id/presence", userHandler.RequireAuth, userHandler.RequireScope(users.ScopeUsersRead), userHandler.GetPresence)
+
+	// Rooms Routings, only reachable with a valid session token
+	wsRoutes := r.Group("/ws", userHandler.RequireAuth)
+	wsRoutes.POST("/create-room", userHandler.RequireScope(users.ScopeRoomsWrite), userHandler.RequireVerifiedEmail, userHandler.RequirePermission(users.PermCreateRooms), websocketHandler.CreateRoom)
+	wsRoutes.GET("/get-room", userHandler.RequireScope(users.ScopeRoomsRead), websocketHandler.GetRoom)
+	wsRoutes.GET("/join-room/:roomId", userHandler.RequireScope(users.ScopeMessagesWrite), websocketHandler.JoinRoom)
+	wsRoutes.GET("/get-client/:roomId", userHandler.RequireScope(users.ScopeRoomsRead), websocketHandler.GetClient)
+	wsRoutes.POST("/send-message/:roomId", userHandler.RequireScope(users.ScopeMessagesWrite), websocketHandler.SendMessage)
+
+	// Admin Routings, each group needs a login session and a role with the given permission
+	adminUsers := r.Group("/admin/users", userHandler.RequireAuth, userHandler.RequireSession, userHandler.RequirePermission(users.PermManageUsers))
+	adminUsers.GET("/:id", userHandler.GetUser)
+	adminUsers.PUT("/:id/role", userHandler.SetRole)
+	adminUsers.POST("/:id/revoke-sessions", userHandler.RevokeSessions)
+	adminUsers.DELETE("/:id", userHandler.DeleteUser)
+
+	adminLockouts := r.Group("/admin/lockouts", userHandler.RequireAuth, userHandler.RequireSession, userHandler.RequirePermission(users.PermManageUsers))
+	adminLockouts.GET("", userHandler.ListLockouts)
+	adminLockouts.DELETE("", userHandler.Unlock)
+
+	adminAudit := r.Group("/admin/audit", userHandler.RequireAuth, userHandler.RequireSession, userHandler.RequirePermission(users.PermViewAudit))
+	adminAudit.GET("", userHandler.ListAuditEvents)
+	adminAudit.GET("/export", userHandler.ExportAuditEvents)
+
+	adminRooms := r.Group("/admin/rooms", userHandler.RequireAuth, userHandler.RequireSession, userHandler.RequirePermission(users.PermManageRooms))
+	adminRooms.GET("", websocketHandler.ListRooms)
+	adminRooms.DELETE("/:roomId", websocketHandler.DeleteRoom)
 
-	// Rooms Routings
-	r.POST("/ws/create-room", websocketHandler.CreateRoom)
-	r.GET("/ws/get-room", websocketHandler.GetRoom)
-	r.GET("/ws/join-room/:roomId", websocketHandler.JoinRoom)
-	r.GET("/ws/get-client/:roomId", websocketHandler.GetClient)
+	return nil
 }
 
 func Start(addr string) error {
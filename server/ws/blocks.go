@@ -0,0 +1,110 @@
+package ws
+
+import "context"
+
+// BlockSource loads the users blocked by a user. It is implemented by users.Service.
+type BlockSource interface {
+	BlockedUserIDs(c context.Context, userID string) ([]string, error)
+}
+
+// blockLoad follows the clients of a user between the moment their blocks are read from the BlockSource
+// and their registration, so that a change made meanwhile is not lost.
+type blockLoad struct {
+	clients int      // Clients of the user whose blocks are being loaded.
+	changed bool     // Set when SetBlockedUsers was called during the load.
+	blocked []string // The list given to SetBlockedUsers, newer than the loaded ones when changed.
+}
+
+// SetBlockedUsers replaces the users blocked by userID, whose messages are no longer delivered to them.
+// Only connected users have their blocks cached, the blocks of others are loaded when they connect;
+// a change made while they are loaded replaces the loaded list when the client registers.
+// It implements users.BlockCache.
+func (h *Hub) SetBlockedUsers(userID string, blockedIDs []string) {
+	h.blocksMu.Lock()
+	defer h.blocksMu.Unlock()
+	if _, ok := h.blocks[userID]; ok {
+		h.blocks[userID] = blockSet(blockedIDs)
+	}
+	if load, ok := h.loads[userID]; ok {
+		load.changed = true
+		load.blocked = blockedIDs
+	}
+}
+
+// blockedUsers reads the users blocked by userID from the BlockSource, for a client about to register.
+// The load is followed from before the read, so SetBlockedUsers calls that the read may have missed are
+// applied by cacheBlocks. A client that does not reach the Register channel must call endBlockLoad.
+func (h *Hub) blockedUsers(ctx context.Context, userID string) ([]string, error) {
+	h.blocksMu.Lock()
+	load, ok := h.loads[userID]
+	if !ok {
+		load = &blockLoad{}
+		h.loads[userID] = load
+	}
+	load.clients++
+	h.blocksMu.Unlock()
+
+	if h.Blocks == nil {
+		return nil, nil
+	}
+	return h.Blocks.BlockedUserIDs(ctx, userID)
+}
+
+// endBlockLoad stops following the load of blockedUsers for a client that will not register.
+func (h *Hub) endBlockLoad(userID string) {
+	h.blocksMu.Lock()
+	defer h.blocksMu.Unlock()
+	h.endBlockLoadLocked(userID)
+}
+
+// endBlockLoadLocked is endBlockLoad for callers holding blocksMu.
+func (h *Hub) endBlockLoadLocked(userID string) {
+	load, ok := h.loads[userID]
+	if !ok {
+		return
+	}
+	if load.clients--; load.clients <= 0 {
+		delete(h.loads, userID)
+	}
+}
+
+// cacheBlocks caches the blocks the client brought, unless another connection of the user already did:
+// the cache is kept up to date by SetBlockedUsers while the user stays connected. Blocks changed after
+// the client loaded its list replace that list.
+// It must only be called from the Run goroutine, before the client receives its first message.
+func (h *Hub) cacheBlocks(cl *Client) {
+	h.blocksMu.Lock()
+	defer h.blocksMu.Unlock()
+	if _, ok := h.blocks[cl.ID]; !ok {
+		blocked := cl.blocked
+		if load, ok := h.loads[cl.ID]; ok && load.changed {
+			blocked = load.blocked
+		}
+		h.blocks[cl.ID] = blockSet(blocked)
+	}
+	h.endBlockLoadLocked(cl.ID)
+}
+
+// forgetBlocks drops the cached blocks of a user who is no longer connected.
+// It must only be called from the Run goroutine, when the last client of the user is gone.
+func (h *Hub) forgetBlocks(userID string) {
+	h.blocksMu.Lock()
+	defer h.blocksMu.Unlock()
+	delete(h.blocks, userID)
+}
+
+// isBlocked reports whether recipientID blocked senderID.
+func (h *Hub) isBlocked(recipientID string, senderID string) bool {
+	h.blocksMu.RLock()
+	defer h.blocksMu.RUnlock()
+	return h.blocks[recipientID][senderID]
+}
+
+// blockSet turns a list of user IDs into a set.
+func blockSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
@@ -3,6 +3,8 @@ package ws
 import (
 	"log"
 	"net/http"
+	"server/internal/users"
+	"server/internal/validation"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -39,31 +41,39 @@ func (c *Client) readMessage(hub *Hub) {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error %v", err)
 			}
+			return
+		}
+		if away, ok := presenceSignal(msg); ok {
+			hub.Status <- &StatusUpdate{Client: c, Away: away}
+			continue
 		}
 		m := &Message{
 			Content:  string(msg),
 			RoomID:   c.RoomId,
 			Username: c.Username,
+			UserID:   c.ID,
+			Bot:      c.Bot,
 		}
 		hub.Broadcast <- m
 	}
 }
 
+// GetClient is a Gin HTTP handler function that lists the clients connected to the given room.
 func (hub *Handler) GetClient(c *gin.Context) {
-	var client []ClientResponse
+	if _, ok := currentUser(c); !ok {
+		return
+	}
 
 	roomId := c.Param("roomId")
-
-	if _, ok := hub.hub.Rooms[roomId]; ok {
-		client = make([]ClientResponse, 0)
-		c.JSON(http.StatusOK, client)
+	if !validation.RoomID(roomId) {
+		users.WriteError(c, users.InvalidRequest(errInvalidRoomID))
+		return
 	}
 
-	for _, c := range hub.hub.Rooms[roomId].Clients {
-		client = append(client, ClientResponse{
-			ID:       c.ID,
-			Username: c.Username,
-		})
+	client, ok := hub.hub.roomClients(roomId)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
 	}
 
 	c.JSON(http.StatusOK, client)
@@ -1,6 +1,10 @@
 package ws
 
-import "fmt"
+import (
+	"fmt"
+	"server/internal/users"
+	"strconv"
+)
 
 // NewHub is a constructor function that creates a new Hub instance with empty Rooms, Register, Unregister, and Broadcast channels.
 func NewHub() *Hub {
@@ -9,9 +13,70 @@ func NewHub() *Hub {
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan *Message, 5),
+		Terminate:  make(chan string, 16),
+		Disconnect: make(chan string, 16),
+		Anonymize:  make(chan string, 16),
+		CloseRoom:  make(chan string, 16),
+		Status:     make(chan *StatusUpdate, 16),
+		Notify:     make(chan *Notification, 16),
+		addRoom:    make(chan *roomCreation),
+		presence:   make(map[string]string),
+		blocks:     make(map[string]map[string]bool),
+		loads:      make(map[string]*blockLoad),
+		sessions:   make(map[string]int),
 	}
 }
 
+// TerminateSessions closes every connection opened with one of the given sessions.
+// It implements users.SessionTerminator.
+func (h *Hub) TerminateSessions(sessionIDs ...string) {
+	for _, id := range sessionIDs {
+		h.Terminate <- id
+	}
+}
+
+// TerminateUser closes every connection of the user, whatever the session.
+// It implements users.UserContent.
+func (h *Hub) TerminateUser(userID string) {
+	h.Disconnect <- userID
+}
+
+// AnonymizeUser removes the user as creator of their rooms, which stay open for the other users.
+// It implements users.UserContent.
+func (h *Hub) AnonymizeUser(userID string) {
+	h.Anonymize <- userID
+}
+
+// NotifyContact sends a contact event to every open connection of the user.
+// The message has the event as type and the ID and username of the other user.
+// It implements users.ContactNotifier.
+func (h *Hub) NotifyContact(userID string, event string, from users.Profile) {
+	h.Notify <- &Notification{
+		UserID: userID,
+		Message: &Message{
+			Type:     event,
+			UserID:   strconv.FormatInt(from.ID, 10),
+			Username: from.Username,
+			Bot:      from.Bot,
+		},
+	}
+}
+
+// RoomsCreatedBy lists the rooms created by the user.
+// It implements users.UserContent.
+func (h *Hub) RoomsCreatedBy(userID string) []users.ExportedRoom {
+	h.roomsMu.RLock()
+	defer h.roomsMu.RUnlock()
+
+	rooms := make([]users.ExportedRoom, 0)
+	for _, r := range h.Rooms {
+		if r.CreatedBy == userID {
+			rooms = append(rooms, users.ExportedRoom{ID: r.ID, Name: r.Name})
+		}
+	}
+	return rooms
+}
+
 // Run is a method of the Hub struct that runs the Hub's main loop.
 // It listens for new clients on the Register channel, unregisters clients on the Unregister channel, and broadcasts messages on the Broadcast channel.
 func (h *Hub) Run() {
@@ -19,40 +84,138 @@ func (h *Hub) Run() {
 		select {
 		// Register is a channel that receives new clients.
 		// If the client's room exists, it adds the client to the room's Clients map.
+		// Otherwise the client's Message channel is closed, which closes its connection.
 		case cl := <-h.Register:
+			registered := false
 			if _, ok := h.Rooms[cl.RoomId]; ok {
 				r := h.Rooms[cl.RoomId]
-				if _, ok := h.Rooms[cl.ID]; !ok {
+				if _, ok := r.Clients[cl.ID]; !ok {
+					h.roomsMu.Lock()
 					r.Clients[cl.ID] = cl
+					h.roomsMu.Unlock()
+					registered = true
 				}
 			}
+			if !registered {
+				h.endBlockLoad(cl.ID)
+				close(cl.Message)
+			} else {
+				h.cacheBlocks(cl)
+				h.countConnection(cl, 1)
+				h.updatePresence(cl.ID, cl.Username, cl.Bot, "")
+			}
 		// Unregister is a channel that receives clients to be unregistered.
 		// If the client's room exists, it removes the client from the room's Clients map.
 		// If the room is empty after unregistering the client, it broadcasts a message indicating that the user left the chat.
 		case cl := <-h.Unregister:
 			if _, ok := h.Rooms[cl.RoomId]; ok {
-				if _, ok := h.Rooms[cl.ID]; ok {
+				if current, ok := h.Rooms[cl.RoomId].Clients[cl.ID]; ok && current == cl {
+					h.roomsMu.Lock()
+					delete(h.Rooms[cl.RoomId].Clients, cl.ID)
+					h.roomsMu.Unlock()
+					close(cl.Message)
+					h.countConnection(cl, -1)
+
 					if len(h.Rooms[cl.RoomId].Clients) != 0 {
-						h.Broadcast <- &Message{
+						h.broadcast(&Message{
 							Content:  fmt.Sprintf("user %s left the chat", cl.ID),
 							RoomID:   cl.RoomId,
 							Username: cl.Username,
-						}
+							UserID:   cl.ID,
+						})
 					}
-
-					delete(h.Rooms[cl.RoomId].Clients, cl.ID)
+					h.updatePresence(cl.ID, cl.Username, cl.Bot, cl.RoomId)
 				}
 			}
 		// Broadcast is a channel that receives messages to be broadcasted.
 		// If the message's room exists, it sends the message to all clients in the room.
 		case msg := <-h.Broadcast:
-			if _, ok := h.Rooms[msg.RoomID]; ok {
-
-				for _, cl := range h.Rooms[msg.RoomID].Clients {
-					// Send the message to all clients
-					cl.Message <- msg
+			h.broadcast(msg)
+		// Status is a channel that receives the away and back signals of clients.
+		// Signals of clients that are no longer registered are ignored.
+		case u := <-h.Status:
+			if r, ok := h.Rooms[u.Client.RoomId]; ok {
+				if current, ok := r.Clients[u.Client.ID]; ok && current == u.Client {
+					current.Away = u.Away
+					h.updatePresence(current.ID, current.Username, current.Bot, "")
+				}
+			}
+		// Notify is a channel that receives messages for a user rather than a room.
+		// Each open connection of the user gets a copy with its room ID.
+		case n := <-h.Notify:
+			for _, r := range h.Rooms {
+				if cl, ok := r.Clients[n.UserID]; ok {
+					msg := *n.Message
+					msg.RoomID = r.ID
+					cl.Message <- &msg
+				}
+			}
+		// Terminate is a channel that receives revoked session IDs.
+		// Closing the connection makes the client's readMessage fail, which unregisters it.
+		case sessionID := <-h.Terminate:
+			for _, r := range h.Rooms {
+				for _, cl := range r.Clients {
+					if cl.SessionID == sessionID {
+						cl.Conn.Close()
+					}
 				}
 			}
+		// Disconnect is a channel that receives the IDs of users whose connections must all be closed.
+		case userID := <-h.Disconnect:
+			for _, r := range h.Rooms {
+				if cl, ok := r.Clients[userID]; ok {
+					cl.Conn.Close()
+				}
+			}
+		// addRoom receives the rooms to create, the ID of an existing room is refused.
+		case req := <-h.addRoom:
+			if _, ok := h.Rooms[req.room.ID]; ok {
+				req.done <- ErrRoomExists
+			} else {
+				h.roomsMu.Lock()
+				h.Rooms[req.room.ID] = req.room
+				h.roomsMu.Unlock()
+				req.done <- nil
+			}
+		// CloseRoom is a channel that receives the IDs of rooms to remove.
+		// Their clients are removed with the room here: closing their Message channel stops their writeMessage,
+		// and closing their connection stops their readMessage, whose Unregister no longer finds the room.
+		case roomID := <-h.CloseRoom:
+			if r, ok := h.Rooms[roomID]; ok {
+				h.roomsMu.Lock()
+				delete(h.Rooms, roomID)
+				h.roomsMu.Unlock()
+				for _, cl := range r.Clients {
+					close(cl.Message)
+					cl.Conn.Close()
+					h.countConnection(cl, -1)
+					h.updatePresence(cl.ID, cl.Username, cl.Bot, "")
+				}
+			}
+		// Anonymize is a channel that receives the IDs of purged users.
+		case userID := <-h.Anonymize:
+			h.roomsMu.Lock()
+			for _, r := range h.Rooms {
+				if r.CreatedBy == userID {
+					r.CreatedBy = ""
+				}
+			}
+			h.roomsMu.Unlock()
+		}
+	}
+}
+
+// broadcast sends the message to all clients of its room, if the room exists,
+// except the clients whose user blocked the sender.
+// It must only be called from the Run goroutine.
+func (h *Hub) broadcast(msg *Message) {
+	if _, ok := h.Rooms[msg.RoomID]; ok {
+		for _, cl := range h.Rooms[msg.RoomID].Clients {
+			if msg.UserID != "" && h.isBlocked(cl.ID, msg.UserID) {
+				continue
+			}
+			// Send the message to all clients
+			cl.Message <- msg
 		}
 	}
 }
@@ -0,0 +1,172 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// startHub runs a hub with the given rooms.
+func startHub(t *testing.T, roomIDs ...string) *Hub {
+	t.Helper()
+	h := NewHub()
+	go h.Run()
+	for _, id := range roomIDs {
+		if err := h.AddRoom(&Room{ID: id, Name: id, Clients: make(map[string]*Client)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+// connect registers a client without a connection, blocking the given users.
+func connect(h *Hub, userID string, roomID string, blocked ...string) *Client {
+	cl := &Client{Message: make(chan *Message, 32), ID: userID, RoomId: roomID, Username: userID, blocked: blocked}
+	h.Register <- cl
+	return cl
+}
+
+// deliveries sends a chat message from senderID to the room and returns the users who received it.
+// A message without sender follows it, which every client receives, so that the hub is done with the first one.
+func deliveries(t *testing.T, h *Hub, roomID string, senderID string, clients ...*Client) []string {
+	t.Helper()
+	content := fmt.Sprintf("hello from %s", senderID)
+	h.Broadcast <- &Message{Content: content, RoomID: roomID, UserID: senderID, Username: senderID}
+	h.Broadcast <- &Message{Content: "end", RoomID: roomID}
+
+	var got []string
+	for _, cl := range clients {
+		for msg := range cl.Message {
+			if msg.Content == "end" {
+				break
+			}
+			if msg.Content == content {
+				got = append(got, cl.ID)
+			}
+		}
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestHubBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocked map[string][]string // Blocks brought by each client when connecting.
+		updated map[string][]string // Blocks changed once connected.
+		from    string
+		want    []string
+	}{
+		{
+			name:    "blocked sender",
+			blocked: map[string][]string{"alice": {"bob"}},
+			from:    "bob",
+			want:    []string{"bob", "carol"},
+		},
+		{
+			name:    "other sender",
+			blocked: map[string][]string{"alice": {"bob"}},
+			from:    "carol",
+			want:    []string{"alice", "bob", "carol"},
+		},
+		{
+			name:    "blocked while connected",
+			blocked: map[string][]string{"alice": {"bob"}},
+			updated: map[string][]string{"alice": {"bob", "carol"}},
+			from:    "carol",
+			want:    []string{"bob", "carol"},
+		},
+		{
+			name:    "unblocked while connected",
+			blocked: map[string][]string{"alice": {"bob"}},
+			updated: map[string][]string{"alice": nil},
+			from:    "bob",
+			want:    []string{"alice", "bob", "carol"},
+		},
+		{
+			name:    "blocks of disconnected users",
+			updated: map[string][]string{"dave": {"bob"}},
+			from:    "bob",
+			want:    []string{"alice", "bob", "carol"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startHub(t, "room")
+			var clients []*Client
+			for _, id := range []string{"alice", "bob", "carol"} {
+				clients = append(clients, connect(h, id, "room", tt.blocked[id]...))
+			}
+			for id, blocked := range tt.updated {
+				h.SetBlockedUsers(id, blocked)
+			}
+
+			if got := deliveries(t, h, "room", tt.from, clients...); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("delivered to %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubKeepsBlocksOfConnectedUsers(t *testing.T) {
+	h := startHub(t, "first", "second")
+	alice := connect(h, "alice", "first", "bob")
+	bob := connect(h, "bob", "second")
+
+	// The list brought by a second connection may be older than the cached one.
+	aliceAgain := connect(h, "alice", "second")
+	if got := deliveries(t, h, "second", "bob", aliceAgain, bob); !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Fatalf("delivered to %v, want [bob]", got)
+	}
+
+	// The blocks of a user are forgotten with their last connection, SetBlockedUsers no longer caches them.
+	h.Unregister <- alice
+	h.Unregister <- aliceAgain
+	h.SetBlockedUsers("alice", []string{"carol"})
+	deliveries(t, h, "second", "bob", bob)
+	if h.isBlocked("alice", "bob") || h.isBlocked("alice", "carol") {
+		t.Fatal("blocks of a disconnected user are still cached")
+	}
+}
+
+// staticBlocks is a BlockSource returning the same list for everyone.
+type staticBlocks []string
+
+func (b staticBlocks) BlockedUserIDs(_ context.Context, _ string) ([]string, error) {
+	return b, nil
+}
+
+func TestHubKeepsBlocksChangedWhileLoading(t *testing.T) {
+	h := startHub(t, "room")
+	h.Blocks = staticBlocks{"bob"}
+
+	// Alice blocks carol and unblocks bob after her new connection read the list, before it registers.
+	blocked, err := h.blockedUsers(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetBlockedUsers("alice", []string{"carol"})
+	alice := connect(h, "alice", "room", blocked...)
+	bob := connect(h, "bob", "room")
+	carol := connect(h, "carol", "room")
+
+	if got := deliveries(t, h, "room", "bob", alice, bob, carol); !reflect.DeepEqual(got, []string{"alice", "bob", "carol"}) {
+		t.Fatalf("bob's message delivered to %v, want everyone", got)
+	}
+	if got := deliveries(t, h, "room", "carol", alice, bob, carol); !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+		t.Fatalf("carol's message delivered to %v, want [bob carol]", got)
+	}
+
+	// A load that ends without registering is forgotten.
+	if _, err := h.blockedUsers(context.Background(), "dave"); err != nil {
+		t.Fatal(err)
+	}
+	h.endBlockLoad("dave")
+	h.blocksMu.RLock()
+	defer h.blocksMu.RUnlock()
+	if len(h.loads) != 0 {
+		t.Fatalf("loads = %v, want none left", h.loads)
+	}
+}
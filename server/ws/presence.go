@@ -0,0 +1,142 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"server/internal/users"
+	"strconv"
+	"time"
+)
+
+// MessageTypePresence is the type of the messages announcing that a user came online, went away or offline.
+// Chat messages have no type.
+const MessageTypePresence = "presence"
+
+// lastSeenTimeout bounds the database write of a last seen time, which runs outside the Run goroutine.
+const lastSeenTimeout = 5 * time.Second
+
+// LastSeenRecorder stores when users were last connected. It is implemented by users.Service.
+type LastSeenRecorder interface {
+	RecordLastSeen(c context.Context, userID int64, at time.Time) error
+}
+
+// StatusUpdate is an away or back signal sent by a client.
+type StatusUpdate struct {
+	Client *Client
+	Away   bool
+}
+
+// clientEvent is a control frame sent by a client instead of a chat message.
+type clientEvent struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+// presenceSignal reports whether msg is a presence frame, {"type":"presence","status":"away"} or "online",
+// and whether it marks the client as away. Every other frame is a chat message.
+func presenceSignal(msg []byte) (away bool, ok bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(msg), []byte("{")) {
+		return false, false
+	}
+	var ev clientEvent
+	if err := json.Unmarshal(msg, &ev); err != nil || ev.Type != MessageTypePresence {
+		return false, false
+	}
+	switch ev.Status {
+	case users.PresenceAway:
+		return true, true
+	case users.PresenceOnline:
+		return false, true
+	}
+	return false, false
+}
+
+// UserPresence returns the status of the user aggregated over their clients in every room:
+// online if one of them is active, away if all of them are away, offline without clients.
+// It implements users.PresenceSource.
+func (h *Hub) UserPresence(userID string) string {
+	h.presenceMu.RLock()
+	defer h.presenceMu.RUnlock()
+
+	if status, ok := h.presence[userID]; ok {
+		return status
+	}
+	return users.PresenceOffline
+}
+
+// updatePresence recomputes the status of the user from their clients. When it changed, the new status is
+// broadcast to the rooms the user is in and to leftRoom, the room they just left, and the last seen time is
+// recorded when the user comes online or goes offline.
+// It must only be called from the Run goroutine.
+func (h *Hub) updatePresence(userID string, username string, bot bool, leftRoom string) {
+	status := users.PresenceOffline
+	var rooms []string
+	for _, r := range h.Rooms {
+		cl, ok := r.Clients[userID]
+		if !ok {
+			continue
+		}
+		rooms = append(rooms, r.ID)
+		if !cl.Away {
+			status = users.PresenceOnline
+		} else if status == users.PresenceOffline {
+			status = users.PresenceAway
+		}
+	}
+
+	h.presenceMu.Lock()
+	previous, ok := h.presence[userID]
+	if !ok {
+		previous = users.PresenceOffline
+	}
+	if status == users.PresenceOffline {
+		delete(h.presence, userID)
+		h.forgetBlocks(userID)
+	} else {
+		h.presence[userID] = status
+	}
+	h.presenceMu.Unlock()
+
+	if status == previous {
+		return
+	}
+	if leftRoom != "" {
+		rooms = append(rooms, leftRoom)
+	}
+	for _, roomID := range rooms {
+		h.broadcast(&Message{
+			Type:     MessageTypePresence,
+			RoomID:   roomID,
+			UserID:   userID,
+			Username: username,
+			Status:   status,
+			Bot:      bot,
+		})
+	}
+	if status == users.PresenceOffline || previous == users.PresenceOffline {
+		h.recordLastSeen(userID)
+	}
+}
+
+// recordLastSeen stores the current time as the last seen time of the user in the background,
+// so a slow database does not hold up the Run goroutine.
+func (h *Hub) recordLastSeen(userID string) {
+	if h.LastSeen == nil {
+		return
+	}
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return
+	}
+	at := time.Now()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), lastSeenTimeout)
+		defer cancel()
+		if err := h.LastSeen.RecordLastSeen(ctx, id, at); err != nil {
+			log.Printf("presence: record last seen of user %s: %v", userID, err)
+		}
+	}()
+}
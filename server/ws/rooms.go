@@ -0,0 +1,64 @@
+package ws
+
+import "errors"
+
+// ErrRoomExists is returned when creating a room with the ID of an existing room.
+var ErrRoomExists = errors.New("room already exists")
+
+// roomCreation asks the Run goroutine to add a room, the outcome is sent on done.
+type roomCreation struct {
+	room *Room
+	done chan error
+}
+
+// AddRoom adds a room to the hub, or returns ErrRoomExists when its ID is taken.
+func (h *Hub) AddRoom(room *Room) error {
+	req := &roomCreation{room: room, done: make(chan error, 1)}
+	h.addRoom <- req
+	return <-req.done
+}
+
+// hasRoom reports whether the room exists.
+func (h *Hub) hasRoom(roomID string) bool {
+	h.roomsMu.RLock()
+	defer h.roomsMu.RUnlock()
+	_, ok := h.Rooms[roomID]
+	return ok
+}
+
+// listRooms returns every room with its creator and number of clients.
+func (h *Hub) listRooms() []AdminRoomRes {
+	h.roomsMu.RLock()
+	defer h.roomsMu.RUnlock()
+
+	rooms := make([]AdminRoomRes, 0, len(h.Rooms))
+	for _, r := range h.Rooms {
+		rooms = append(rooms, AdminRoomRes{
+			ID:        r.ID,
+			Name:      r.Name,
+			CreatedBy: r.CreatedBy,
+			Clients:   len(r.Clients),
+		})
+	}
+	return rooms
+}
+
+// roomClients returns the clients connected to the room, and false when the room does not exist.
+func (h *Hub) roomClients(roomID string) ([]ClientResponse, bool) {
+	h.roomsMu.RLock()
+	defer h.roomsMu.RUnlock()
+
+	room, ok := h.Rooms[roomID]
+	if !ok {
+		return nil, false
+	}
+	clients := make([]ClientResponse, 0, len(room.Clients))
+	for _, cl := range room.Clients {
+		clients = append(clients, ClientResponse{
+			ID:       cl.ID,
+			Username: cl.Username,
+			Bot:      cl.Bot,
+		})
+	}
+	return clients, true
+}
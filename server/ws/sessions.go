@@ -0,0 +1,26 @@
+package ws
+
+// SessionConnections returns how many connections the login session has open in this hub.
+// It implements users.ConnectionCounter.
+func (h *Hub) SessionConnections(sessionID string) int {
+	h.sessionsMu.RLock()
+	defer h.sessionsMu.RUnlock()
+	return h.sessions[sessionID]
+}
+
+// countConnection adds delta to the open connections of the client's session.
+// It must only be called from the Run goroutine, when the client is added to or removed from a room.
+func (h *Hub) countConnection(cl *Client, delta int) {
+	if cl.SessionID == "" {
+		return
+	}
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+
+	n := h.sessions[cl.SessionID] + delta
+	if n <= 0 {
+		delete(h.sessions, cl.SessionID)
+	} else {
+		h.sessions[cl.SessionID] = n
+	}
+}
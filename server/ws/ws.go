@@ -1,17 +1,22 @@
 package ws
 
-import "github.com/gorilla/websocket"
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
 
 // Room Section
 type CreateRoomReq struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID   string `json:"id" binding:"required,room_id"`
+	Name string `json:"name" binding:"required,max=100"`
 }
 
 type Room struct {
-	ID      string             `json:"id"`
-	Name    string             `json:"name"`
-	Clients map[string]*Client `json:"clients"`
+	ID        string             `json:"id"`
+	Name      string             `json:"name"`
+	CreatedBy string             `json:"created_by"`
+	Clients   map[string]*Client `json:"clients"`
 }
 
 type RoomRes struct {
@@ -19,29 +24,86 @@ type RoomRes struct {
 	Name string `json:"name"`
 }
 
+// AdminRoomRes is a room as seen by moderators and administrators.
+type AdminRoomRes struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedBy string `json:"created_by"`
+	Clients   int    `json:"clients"`
+}
+
 type Hub struct {
-	Rooms      map[string]*Room `json:"rooms"`
+	Rooms      map[string]*Room `json:"rooms"` // Written by Run only, under roomsMu.
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan *Message
+	Terminate  chan string
+	Disconnect chan string
+	Anonymize  chan string
+	CloseRoom  chan string
+	Status     chan *StatusUpdate
+	Notify     chan *Notification
+
+	addRoom chan *roomCreation
+	roomsMu sync.RWMutex
+
+	// LastSeen stores when users were last connected, may be nil.
+	LastSeen LastSeenRecorder
+
+	// Blocks loads the users blocked by a user when they connect, may be nil.
+	Blocks BlockSource
+
+	presenceMu sync.RWMutex
+	presence   map[string]string // Status of the connected users, written by Run only.
+
+	blocksMu sync.RWMutex
+	blocks   map[string]map[string]bool // Users blocked by each connected user, added and removed by Run only.
+	loads    map[string]*blockLoad      // Blocks being loaded for clients about to register.
+
+	sessionsMu sync.RWMutex
+	sessions   map[string]int // Open connections of each login session, written by Run only.
 }
 
 // Peer2Peer Section
 type Client struct {
-	Conn     *websocket.Conn
-	Message  chan *Message
-	ID       string `json:"id"`
-	RoomId   string `json:"room_id"`
-	Username string `json:"username"`
+	Conn      *websocket.Conn
+	Message   chan *Message
+	ID        string `json:"id"`
+	RoomId    string `json:"room_id"`
+	Username  string `json:"username"`
+	SessionID string `json:"-"`
+	Bot       bool   `json:"bot"`
+	Away      bool   `json:"-"` // Set by the client's presence frames, read and written by Hub.Run only.
+
+	blocked []string // Users blocked by the user when the client connected, cached by Hub.Run on registration.
+}
+
+// Notification is a message for every client of a user, whatever their room.
+type Notification struct {
+	UserID  string
+	Message *Message
 }
 
 type ClientResponse struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
+	Bot      bool   `json:"bot"`
 }
 
+// Message is a chat message. Bot is set when a bot account sent it, so clients can show it differently.
+// UserID is the sender, messages from users a client blocked are not delivered to it.
+// Presence messages have the MessageTypePresence type, the user's ID and their new status instead of content.
 type Message struct {
+	Type     string `json:"type,omitempty"`
 	Content  string `json:"content"`
 	Username string `json:"username"`
 	RoomID   string `json:"room_id"`
+	Bot      bool   `json:"bot,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// SendMessageReq is a chat message posted over HTTP instead of a WebSocket.
+type SendMessageReq struct {
+	Content string `json:"content" binding:"required,max=8192"`
 }
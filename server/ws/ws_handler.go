@@ -1,13 +1,22 @@
 package ws
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"server/internal/users"
+	"server/internal/validation"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+// maxMessageSize is the largest chat message a client may send, larger messages close the connection.
+const maxMessageSize = 8 << 10
+
+// errInvalidRoomID is returned for a roomId path parameter that cannot be a room ID.
+var errInvalidRoomID = errors.New("roomId must be 1 to 64 letters, digits, dashes or underscores")
+
 // Handler struct contains a reference to the Hub, which manages the rooms and clients.
 type Handler struct {
 	hub *Hub
@@ -18,27 +27,77 @@ func NewHandler(hub *Hub) *Handler {
 	return &Handler{hub: hub}
 }
 
+// currentUser returns the identity injected by users.RequireAuth.
+// It writes HTTP 401 (Unauthorized) and returns false when the request is not authenticated.
+func currentUser(c *gin.Context) (*users.MyJWTClaims, bool) {
+	claims, ok := users.GetClaims(c)
+	if !ok {
+		users.AbortWithError(c, users.ErrNotAuthenticated)
+		return nil, false
+	}
+	return claims, true
+}
+
 // CreateRoom is a Gin HTTP handler function that creates a new room with the given ID and name.
-// It adds the new room to the Hub's Rooms map.
+// It adds the new room to the Hub's Rooms map and records the authenticated user as its creator.
+// An existing room is never replaced, its ID answers HTTP 409 (Conflict).
 func (hub *Handler) CreateRoom(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
 	var request CreateRoomReq
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		users.WriteError(c, users.InvalidRequest(err))
 		return
 	}
 
-	hub.hub.Rooms[request.ID] = &Room{
-		ID:      request.ID,
-		Name:    request.Name,
-		Clients: make(map[string]*Client),
+	err := hub.hub.AddRoom(&Room{
+		ID:        request.ID,
+		Name:      request.Name,
+		CreatedBy: user.ID,
+		Clients:   make(map[string]*Client),
+	})
+	if errors.Is(err, ErrRoomExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "room already exists"})
+		return
 	}
 	c.JSON(http.StatusOK, request)
 }
 
+// ListRooms is a Gin HTTP handler function that lists every room with its creator and number of clients,
+// for moderators and administrators.
+func (hub *Handler) ListRooms(c *gin.Context) {
+	c.JSON(http.StatusOK, hub.hub.listRooms())
+}
+
+// DeleteRoom is a Gin HTTP handler function that closes the room in the ":roomId" path parameter
+// and disconnects its clients.
+func (hub *Handler) DeleteRoom(c *gin.Context) {
+	roomID := c.Param("roomId")
+	if !validation.RoomID(roomID) {
+		users.WriteError(c, users.InvalidRequest(errInvalidRoomID))
+		return
+	}
+	if !hub.hub.hasRoom(roomID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+
+	hub.hub.CloseRoom <- roomID
+	c.JSON(http.StatusOK, gin.H{"message": "Room Deleted Successfully"})
+}
+
+// GetRoom is a Gin HTTP handler function that lists every room in the Hub.
 func (hub *Handler) GetRoom(c *gin.Context) {
+	if _, ok := currentUser(c); !ok {
+		return
+	}
+
 	room := make([]RoomRes, 0)
 
-	for _, val := range hub.hub.Rooms {
+	for _, val := range hub.hub.listRooms() {
 		room = append(room, RoomRes{
 			ID:   val.ID,
 			Name: val.Name,
@@ -58,30 +117,55 @@ var upgrader = websocket.Upgrader{
 
 // JoinRoom is a Gin HTTP handler function that upgrades the HTTP connection to a WebSocket connection and adds the new client to the specified room.
 // It also broadcasts a message to the room indicating that a new user has joined.
+// The client's ID and username come from the authenticated session, never from the query string.
 func (hub *Handler) JoinRoom(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	user, ok := currentUser(c)
+	if !ok {
 		return
 	}
 
-	// Route /ws/JoinRoom/:roomId?userId=1&username=user
+	// Route /ws/join-room/:roomId
 	roomID := c.Param("roomId")
-	clientID := c.Query("userId")
-	username := c.Query("username")
+	if !validation.RoomID(roomID) {
+		users.WriteError(c, users.InvalidRequest(errInvalidRoomID))
+		return
+	}
+
+	// The users blocked by the new client must be known before it receives its first message
+	blocked, err := hub.hub.blockedUsers(c.Request.Context(), user.ID)
+	if err != nil {
+		hub.hub.endBlockLoad(user.ID)
+		users.WriteError(c, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied to the client with an HTTP error.
+		hub.hub.endBlockLoad(user.ID)
+		return
+	}
+	conn.SetReadLimit(maxMessageSize)
+	clientID := user.ID
+	username := user.Username
 
 	client := &Client{
-		ID:       clientID,
-		Username: username,
-		RoomId:   roomID,
-		Conn:     conn,
-		Message:  make(chan *Message, 10), // Buffer Message of 10
+		ID:        clientID,
+		Username:  username,
+		RoomId:    roomID,
+		SessionID: user.SessionID,
+		Bot:       user.Bot,
+		Conn:      conn,
+		Message:   make(chan *Message, 10), // Buffer Message of 10
+		blocked:   blocked,
 	}
 
 	message := &Message{
 		Username: username,
 		RoomID:   roomID,
 		Content:  fmt.Sprintf("New user are joining the room %s", roomID),
+		UserID:   user.ID,
+		Bot:      user.Bot,
 	}
 
 	// Register a new Client through the register channel
@@ -92,3 +176,39 @@ func (hub *Handler) JoinRoom(c *gin.Context) {
 	go client.writeMessage()
 	client.readMessage(hub.hub)
 }
+
+// SendMessage is a Gin HTTP handler function that posts a message to the room in the ":roomId" path parameter
+// without joining it, for bots and scripts that do not keep a WebSocket open.
+func (hub *Handler) SendMessage(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	roomID := c.Param("roomId")
+	if !validation.RoomID(roomID) {
+		users.WriteError(c, users.InvalidRequest(errInvalidRoomID))
+		return
+	}
+
+	var request SendMessageReq
+	if err := c.ShouldBindJSON(&request); err != nil {
+		users.WriteError(c, users.InvalidRequest(err))
+		return
+	}
+
+	if !hub.hub.hasRoom(roomID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+
+	message := &Message{
+		Content:  request.Content,
+		Username: user.Username,
+		RoomID:   roomID,
+		UserID:   user.ID,
+		Bot:      user.Bot,
+	}
+	hub.hub.Broadcast <- message
+	c.JSON(http.StatusAccepted, message)
+}